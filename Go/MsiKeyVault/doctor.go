@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azdoctor"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// runDoctor checks the environment, identity, network and keyvault access
+// policy the other commands rely on and prints what to fix
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	secretRefs := fs.Args()
+	if len(secretRefs) == 0 {
+		if secretName, ok := os.LookupEnv("KEYVAULT_SECRET_NAME"); ok {
+			secretRefs = []string{secretName}
+		}
+	}
+
+	ctx := context.Background()
+	report := &azdoctor.Report{}
+	report.Add(azdoctor.Env("KEYVAULT_VAULT_NAME"))
+
+	res, chain := azdoctor.Credential(msiIdentity())
+	report.Add(res)
+
+	if chain != nil {
+		report.Add(azdoctor.IMDS(ctx, chain))
+		results, claims := azdoctor.Tokens(ctx, chain, "keyvault")
+		report.Add(results...)
+
+		if vaultName := os.Getenv("KEYVAULT_VAULT_NAME"); vaultName != "" {
+			report.Add(azdoctor.DNS(ctx, azenv.VaultURL(chain.Environment, vaultName)))
+
+			// Failures are reported as they happen instead of being ridden out
+			keyClient := azkeyvault.NewKeyVaultClientWithPolicy(chain.Environment, vaultName, chain, azretry.NoRetries)
+
+			for _, ref := range secretRefs {
+				report.Add(azdoctor.SecretAccess(ctx, keyClient, vaultName, ref, claims["keyvault"]))
+			}
+
+			if len(secretRefs) == 0 {
+				report.Add(azdoctor.Result{Check: "secrets", Status: azdoctor.Skip, Detail: "pass secret names or set KEYVAULT_SECRET_NAME to check access"})
+			}
+		}
+	}
+
+	if err := report.WriteFormat(os.Stdout, *format); err != nil {
+		return err
+	}
+
+	return report.Err()
+}
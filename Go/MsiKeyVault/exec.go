@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// runExec resolves keyvault references in the environment and replaces the
+// current process with the given command so it inherits the resolved values.
+func runExec(args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("exec requires a command to run")
+	}
+
+	// References carry their own vault, so the default vault is optional here
+	keyClient, err := newKeyVaultClientForVault(os.Getenv("KEYVAULT_VAULT_NAME"))
+	if err != nil {
+		return err
+	}
+
+	env, err := keyClient.ResolveEnv(context.Background(), os.Environ())
+	if err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+
+	return syscall.Exec(path, args, env)
+}
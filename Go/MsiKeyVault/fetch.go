@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+)
+
+// tagFlags collects repeated -tag key=value flags
+type tagFlags map[string]string
+
+func (t tagFlags) String() string {
+	return fmt.Sprint(map[string]string(t))
+}
+
+func (t tagFlags) Set(value string) error {
+	idx := strings.Index(value, "=")
+	if idx <= 0 {
+		return fmt.Errorf("expected key=value, got '%s'", value)
+	}
+
+	t[value[:idx]] = value[idx+1:]
+	return nil
+}
+
+// runFetch fetches every secret in the vault matching a name prefix or tags
+// in parallel and prints them like get does
+func runFetch(args []string) error {
+	var output outputFlags
+	tags := tagFlags{}
+
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "only fetch secrets whose name starts with the prefix")
+	app := fs.String("app", "", "only fetch secrets tagged app=<name>, shorthand for -tag app=<name>")
+	fs.Var(tags, "tag", "only fetch secrets with the tag as key=value, may be repeated")
+	concurrency := fs.Int("concurrency", azkeyvault.DefaultConcurrency, "number of secrets to fetch in parallel")
+	allowPartial := fs.Bool("allow-partial", false, "write the secrets that were fetched even if others failed")
+	output.register(fs)
+	fs.Parse(args)
+
+	if *app != "" {
+		tags["app"] = *app
+	}
+
+	if *prefix == "" && len(tags) == 0 {
+		return fmt.Errorf("fetch requires -prefix, -app or -tag")
+	}
+
+	keyClient, err := newKeyVaultClient()
+	if err != nil {
+		return err
+	}
+
+	getter, err := newSecretGetter(keyClient)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	listed, err := keyClient.ListSecrets(ctx, azkeyvault.SecretFilter{Prefix: *prefix, Tags: tags})
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %v", err)
+	}
+
+	if len(listed) == 0 {
+		return fmt.Errorf("no secrets matched the filter")
+	}
+
+	names := make([]string, 0, len(listed))
+	for _, s := range listed {
+		names = append(names, s.Name)
+	}
+
+	secrets := make([]*azkeyvault.Secret, 0, len(names))
+	failed := 0
+	for _, result := range azkeyvault.GetSecretsByName(ctx, getter, names, *concurrency) {
+		if result.Err != nil {
+			log.Printf("Failed to get secret '%s': %v", result.Name, result.Err)
+			failed++
+			continue
+		}
+		secrets = append(secrets, result.Secret)
+	}
+
+	if failed > 0 && !*allowPartial {
+		return fmt.Errorf("failed to get %d of %d secrets", failed, len(names))
+	}
+
+	if err := output.write(secrets); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to get %d of %d secrets", failed, len(names))
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+)
+
+const keysUsage = `Usage: %s keys <sign|verify|encrypt|decrypt|wrap|unwrap> -key name[@version] [flags]
+`
+
+// runKeys runs a key operation in the vault on a file or stdin
+func runKeys(args []string) error {
+	if len(args) == 0 || !strings.Contains(" sign verify encrypt decrypt wrap unwrap ", " "+args[0]+" ") {
+		return usageError(fmt.Sprintf(keysUsage, os.Args[0]))
+	}
+	op, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("keys "+op, flag.ExitOnError)
+	keyRef := fs.String("key", "", "key name, name@version or key identifier URL")
+	alg := fs.String("alg", "", "algorithm, RS256 or ES256 for signing and RSA-OAEP for encryption by default")
+	in := fs.String("in", "-", "input file, - for stdin")
+	out := fs.String("out", "", "output file, - for stdout, decrypt and unwrap need it or -fd")
+	fd := fs.Int("fd", -1, "file descriptor to write the output to")
+	sigPath := fs.String("sig", "", "signature file to verify")
+	encode := fs.Bool("base64", false, "write output and read signatures as base64")
+	fs.Parse(args)
+
+	if *keyRef == "" {
+		return fmt.Errorf("-key must be set")
+	}
+
+	input, err := readInput(*in)
+	if err != nil {
+		return err
+	}
+
+	keyClient, err := newKeyVaultClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch op {
+	case "sign":
+		result, err := keyClient.SignData(ctx, *keyRef, defaultAlg(*alg, "RS256"), input)
+		if err != nil {
+			return err
+		}
+		log.Printf("Signed with key '%s'", result.KeyID)
+		return writeOutput(*out, *fd, result.Result, *encode, false)
+
+	case "verify":
+		if *sigPath == "" {
+			return fmt.Errorf("verify requires -sig")
+		}
+
+		signature, err := ioutil.ReadFile(*sigPath)
+		if err != nil {
+			return err
+		}
+
+		if *encode {
+			signature, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+			if err != nil {
+				return fmt.Errorf("failed to decode signature: %v", err)
+			}
+		}
+
+		valid, err := keyClient.VerifyData(ctx, *keyRef, defaultAlg(*alg, "RS256"), input, signature)
+		if err != nil {
+			return err
+		}
+
+		if !valid {
+			return fmt.Errorf("signature is not valid")
+		}
+		log.Println("Signature is valid")
+		return nil
+
+	case "encrypt", "decrypt", "wrap", "unwrap":
+		operation := map[string]func(context.Context, string, string, []byte) (*azkeyvault.KeyOperationResult, error){
+			"encrypt": keyClient.Encrypt,
+			"decrypt": keyClient.Decrypt,
+			"wrap":    keyClient.WrapKey,
+			"unwrap":  keyClient.UnwrapKey,
+		}[op]
+
+		result, err := operation(ctx, *keyRef, defaultAlg(*alg, "RSA-OAEP"), input)
+		if err != nil {
+			return err
+		}
+		log.Printf("Ran %s with key '%s'", op, result.KeyID)
+
+		// Decrypted data and unwrapped keys are plaintext, they only go to stdout, i.e. the container's log, when asked to
+		plaintext := op == "decrypt" || op == "unwrap"
+		return writeOutput(*out, *fd, result.Result, *encode, plaintext)
+	}
+
+	return nil
+}
+
+func defaultAlg(alg, def string) string {
+	if alg == "" {
+		return def
+	}
+
+	return alg
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// writeOutput writes the result to the file or file descriptor, or to stdout
+// with -out -. Plaintext results need an explicit destination, everything
+// else goes to stdout by default.
+func writeOutput(path string, fd int, data []byte, encode, plaintext bool) error {
+	if encode {
+		data = []byte(base64.StdEncoding.EncodeToString(data) + "\n")
+	}
+
+	switch {
+	case path != "" && fd >= 0:
+		return fmt.Errorf("only one of -out and -fd can be set")
+	case path == "" && fd < 0 && plaintext:
+		return fmt.Errorf("the output is plaintext and requires -out or -fd, use -out - to write it to stdout")
+	case path != "" && path != "-":
+		return writeFileAtomic(path, data, 0600, -1, -1)
+	case fd >= 0:
+		dest := os.NewFile(uintptr(fd), "fd"+strconv.Itoa(fd))
+		defer dest.Close()
+
+		_, err := dest.Write(data)
+		return err
+	}
+
+	_, err := os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteOutputPlaintextNeedsDestination(t *testing.T) {
+	if err := writeOutput("", -1, []byte("plaintext"), false, true); err == nil {
+		t.Error("plaintext should not be written to stdout by default")
+	}
+
+	if err := writeOutput("-", 3, []byte("plaintext"), false, true); err == nil {
+		t.Error("-out - and -fd together should be refused")
+	}
+}
+
+func TestWriteOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An existing file must not keep a world readable mode
+	path := filepath.Join(dir, "out")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeOutput(path, -1, []byte("plaintext"), true, true); err != nil {
+		t.Fatalf("writeOutput failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "cGxhaW50ZXh0\n" {
+		t.Errorf("wrote %q", data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("output mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestRunKeysUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"shred"}} {
+		err := runKeys(args)
+		if _, ok := err.(usageError); !ok {
+			t.Errorf("runKeys(%q) = %v, want a usage error", args, err)
+		}
+	}
+}
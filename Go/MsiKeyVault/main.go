@@ -2,79 +2,162 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
-	"github.com/Azure/go-autorest/autorest/azure"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
 )
 
+const usage = `Usage: %s [command] [flags]
+
+Commands:
+  get     print the metadata of secrets, KEYVAULT_SECRET_NAME by default
+  fetch   print every secret matching a name prefix or tags
+  init    write secrets to files and exit, for use as an init container
+  set     store a secret from a file or generate a password or keypair
+  exec    resolve keyvault references in the environment and exec a command
+  whoami  show which identity a token is issued to
+  doctor  check the environment, identity, network and access, and explain what to fix
+  keys    sign, verify, encrypt, decrypt, wrap or unwrap with a keyvault key
+`
+
 func main() {
-	vaultName, ok := os.LookupEnv("KEYVAULT_VAULT_NAME")
-	if !ok {
-		log.Fatal("KEYVAULT_VAULT_NAME must be set.")
+	cmd := "get"
+	args := []string{}
+	if len(os.Args) > 1 {
+		cmd, args = os.Args[1], os.Args[2:]
 	}
 
-	secretName, ok := os.LookupEnv("KEYVAULT_SECRET_NAME")
-	if !ok {
-		log.Fatal("KEYVAULT_SECRET_NAME must be set.")
+	var err error
+	switch cmd {
+	case "get":
+		err = runGet(args)
+	case "fetch":
+		err = runFetch(args)
+	case "init":
+		err = runInit(args)
+	case "set":
+		err = runSet(args)
+	case "exec":
+		err = runExec(args)
+	case "whoami":
+		err = runWhoami(args)
+	case "doctor":
+		err = runDoctor(args)
+	case "keys":
+		err = runKeys(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		return
+	default:
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		os.Exit(2)
 	}
 
-	clientID := os.Getenv("MSI_USER_ASSIGNED_CLIENTID")
-
-	keyClient, err := NewKeyVaultClient(vaultName, clientID)
 	if err != nil {
+		if usage, ok := err.(usageError); ok {
+			fmt.Fprint(os.Stderr, string(usage))
+			os.Exit(2)
+		}
 		log.Fatal(err)
 	}
+}
+
+// usageError is returned for invalid command lines, main prints it as the
+// usage text and exits 2 like the flag package does
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func runGet(args []string) error {
+	var output outputFlags
+
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	output.register(fs)
+	fs.Parse(args)
+
+	// Secrets can be pinned to a version with name@version or a full secret identifier URL
+	secretNames := fs.Args()
+	if len(secretNames) == 0 {
+		secretName, ok := os.LookupEnv("KEYVAULT_SECRET_NAME")
+		if !ok {
+			return fmt.Errorf("KEYVAULT_SECRET_NAME must be set")
+		}
+		secretNames = []string{secretName}
+	}
 
-	secret, err := keyClient.GetSecret(secretName)
+	keyClient, err := newKeyVaultClient()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	log.Printf("Retrieved secret '%s' from keyvault using MSI", secret)
-}
+	getter, err := newSecretGetter(keyClient)
+	if err != nil {
+		return err
+	}
 
-// KeyVault holds the information for a keyvault instance
-type KeyVault struct {
-	client   *keyvault.BaseClient
-	vaultURL string
+	secrets := make([]*azkeyvault.Secret, 0, len(secretNames))
+	for _, secretName := range secretNames {
+		secret, err := getter.GetSecret(context.Background(), secretName)
+		if err != nil {
+			return err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	return output.write(secrets)
 }
 
-// NewKeyVaultClient creates a new keyvault client
-func NewKeyVaultClient(vaultName, clientID string) (*KeyVault, error) {
-	msiKeyConfig := &auth.MSIConfig{
-		Resource: strings.TrimSuffix(azure.PublicCloud.KeyVaultEndpoint, "/"),
-		ClientID: clientID,
+// newKeyVaultClient creates a keyvault client from the environment
+func newKeyVaultClient() (*azkeyvault.KeyVault, error) {
+	vaultName, ok := os.LookupEnv("KEYVAULT_VAULT_NAME")
+	if !ok {
+		return nil, fmt.Errorf("KEYVAULT_VAULT_NAME must be set")
 	}
 
-	auth, err := msiKeyConfig.Authorizer()
+	return newKeyVaultClientForVault(vaultName)
+}
+
+// newKeyVaultClientForVault creates a keyvault client for the cloud selected
+// in the environment. The vault name may be empty.
+func newKeyVaultClientForVault(vaultName string) (*azkeyvault.KeyVault, error) {
+	env, err := azenv.FromEnvironment()
 	if err != nil {
 		return nil, err
 	}
 
-	keyClient := keyvault.New()
-	keyClient.Authorizer = auth
+	return azkeyvault.NewKeyVaultClientWithEnvironment(env, vaultName, msiIdentity())
+}
 
-	k := &KeyVault{
-		vaultURL: fmt.Sprintf("https://%s.%s", vaultName, azure.PublicCloud.KeyVaultDNSSuffix),
-		client:   &keyClient,
+// newSecretGetter puts a cache backed by an encrypted snapshot in front of the
+// client when KEYVAULT_SNAPSHOT_PATH is set, so the last known good secrets
+// are served when keyvault can't be reached.
+func newSecretGetter(keyClient *azkeyvault.KeyVault) (azkeyvault.SecretGetter, error) {
+	snapshotPath, ok := os.LookupEnv("KEYVAULT_SNAPSHOT_PATH")
+	if !ok {
+		return keyClient, nil
 	}
 
-	return k, nil
-}
-
-// GetSecret retrieves a secret from keyvault
-func (k *KeyVault) GetSecret(keyName string) (string, error) {
-	ctx := context.Background()
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("KEYVAULT_SNAPSHOT_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("KEYVAULT_SNAPSHOT_KEY must be a base64 encoded 32 byte key: %v", err)
+	}
 
-	keyBundle, err := k.client.GetSecret(ctx, k.vaultURL, keyName, "")
+	snapshot, err := azkeyvault.NewSnapshot(snapshotPath, key)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	cache := keyClient.NewSecretCache(azkeyvault.DefaultCacheTTL)
+	if err := cache.UseSnapshot(snapshot); err != nil {
+		return nil, err
 	}
 
-	return *keyBundle.Value, nil
+	return cache, nil
 }
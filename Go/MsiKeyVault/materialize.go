@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultFileMode = "0400"
+)
+
+// secretFile maps a secret reference to the file it is written to
+type secretFile struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Mode  string `json:"mode,omitempty"`
+	Owner string `json:"owner,omitempty"`
+}
+
+// materializeConfig is the format of the file passed with -config
+type materializeConfig struct {
+	Mode    string       `json:"mode,omitempty"`
+	Owner   string       `json:"owner,omitempty"`
+	Secrets []secretFile `json:"secrets"`
+}
+
+// secretFlags collects repeated -secret name=path flags
+type secretFlags []secretFile
+
+func (s *secretFlags) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *secretFlags) Set(value string) error {
+	idx := strings.Index(value, "=")
+	if idx <= 0 || idx == len(value)-1 {
+		return fmt.Errorf("expected name=path, got '%s'", value)
+	}
+
+	*s = append(*s, secretFile{Name: value[:idx], Path: value[idx+1:]})
+	return nil
+}
+
+// runInit writes each configured secret to its file so an app container
+// sharing the volume can read them, then returns so the process exits 0.
+func runInit(args []string) error {
+	var secrets secretFlags
+
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	configPath := fs.String("config", "", "JSON file mapping secrets to file paths")
+	mode := fs.String("mode", "", "default file mode for written secrets (default "+defaultFileMode+")")
+	owner := fs.String("owner", "", "default owner for written secrets as uid:gid or user:group")
+	fs.Var(&secrets, "secret", "secret to write as name=path, may be repeated")
+	fs.Parse(args)
+
+	config := materializeConfig{}
+	if *configPath != "" {
+		b, err := ioutil.ReadFile(*configPath)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(b, &config); err != nil {
+			return fmt.Errorf("failed to parse config '%s': %v", *configPath, err)
+		}
+	}
+
+	// Flags take precedence over the config file defaults
+	if *mode != "" {
+		config.Mode = *mode
+	}
+	if *owner != "" {
+		config.Owner = *owner
+	}
+	if config.Mode == "" {
+		config.Mode = defaultFileMode
+	}
+	config.Secrets = append(config.Secrets, secrets...)
+
+	if len(config.Secrets) == 0 {
+		return fmt.Errorf("no secrets configured, use -config or -secret name=path")
+	}
+
+	keyClient, err := newKeyVaultClient()
+	if err != nil {
+		return err
+	}
+
+	getter, err := newSecretGetter(keyClient)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	for _, s := range config.Secrets {
+		if s.Name == "" || s.Path == "" {
+			return fmt.Errorf("secret entries need both a name and a path")
+		}
+
+		if s.Mode == "" {
+			s.Mode = config.Mode
+		}
+		if s.Owner == "" {
+			s.Owner = config.Owner
+		}
+
+		fileMode, err := strconv.ParseUint(s.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid file mode '%s' for secret '%s'", s.Mode, s.Name)
+		}
+
+		uid, gid, err := lookupOwner(s.Owner)
+		if err != nil {
+			return err
+		}
+
+		secret, err := getter.GetSecret(ctx, s.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get secret '%s': %v", s.Name, err)
+		}
+
+		if err := writeFileAtomic(s.Path, []byte(secret.Value), os.FileMode(fileMode), uid, gid); err != nil {
+			return fmt.Errorf("failed to write secret '%s': %v", s.Name, err)
+		}
+
+		log.Printf("Wrote secret '%s' version '%s' to '%s'", secret.Name, secret.Version, s.Path)
+	}
+
+	return nil
+}
+
+// lookupOwner resolves uid:gid or user:group, -1 leaves the id unchanged
+func lookupOwner(owner string) (int, int, error) {
+	if owner == "" {
+		return -1, -1, nil
+	}
+
+	parts := strings.SplitN(owner, ":", 2)
+
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		u, err := user.Lookup(parts[0])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid owner '%s': %v", owner, err)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+
+	gid := -1
+	if len(parts) == 2 {
+		gid, err = strconv.Atoi(parts[1])
+		if err != nil {
+			g, err := user.LookupGroup(parts[1])
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid owner '%s': %v", owner, err)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// writeFileAtomic writes to a temp file in the target directory and renames
+// it into place so readers never observe a partially written secret.
+func writeFileAtomic(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if uid != -1 || gid != -1 {
+		if err := tmp.Chown(uid, gid); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "materialize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nested", "db-password")
+	if err := writeFileAtomic(path, []byte("first"), 0400, -1, -1); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	// Overwriting a read only secret must still succeed since the
+	// new value is renamed over the old file
+	if err := writeFileAtomic(path, []byte("second"), 0440, -1, -1); err != nil {
+		t.Fatalf("writeFileAtomic overwrite failed: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "second" {
+		t.Errorf("content = %q, want %q", b, "second")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0440 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0440))
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the secret file, found %d entries", len(entries))
+	}
+}
+
+func TestLookupOwner(t *testing.T) {
+	tests := []struct {
+		owner   string
+		uid     int
+		gid     int
+		wantErr bool
+	}{
+		{owner: "", uid: -1, gid: -1},
+		{owner: "1000", uid: 1000, gid: -1},
+		{owner: "1000:2000", uid: 1000, gid: 2000},
+		{owner: "no-such-user-azkv", wantErr: true},
+		{owner: "1000:no-such-group-azkv", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		uid, gid, err := lookupOwner(tt.owner)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("lookupOwner(%q) = %d, %d, want an error", tt.owner, uid, gid)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("lookupOwner(%q) failed: %v", tt.owner, err)
+			continue
+		}
+
+		if uid != tt.uid || gid != tt.gid {
+			t.Errorf("lookupOwner(%q) = %d, %d, want %d, %d", tt.owner, uid, gid, tt.uid, tt.gid)
+		}
+	}
+}
+
+func TestLookupOwnerByName(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skipf("current user unavailable: %v", err)
+	}
+
+	g, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		t.Skipf("primary group unavailable: %v", err)
+	}
+
+	uid, gid, err := lookupOwner(u.Username + ":" + g.Name)
+	if err != nil {
+		t.Fatalf("lookupOwner failed: %v", err)
+	}
+
+	if strconv.Itoa(uid) != u.Uid || strconv.Itoa(gid) != u.Gid {
+		t.Errorf("lookupOwner(%q) = %d, %d, want %s, %s", u.Username+":"+g.Name, uid, gid, u.Uid, u.Gid)
+	}
+}
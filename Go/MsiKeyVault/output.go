@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+)
+
+const (
+	redacted = "<redacted>"
+)
+
+// outputFormat writes secrets in one of the supported formats
+type outputFormat func(w io.Writer, secrets []*azkeyvault.Secret, opts outputOptions) error
+
+// outputOptions holds the settings shared by the output formats
+type outputOptions struct {
+	Reveal       bool
+	K8sName      string
+	K8sNamespace string
+}
+
+// formats maps the -format flag to the writer for it. Formats that only make
+// sense with values are marked as needing -reveal.
+var formats = map[string]struct {
+	write       outputFormat
+	needsReveal bool
+}{
+	"text":   {writeText, false},
+	"json":   {writeJSON, false},
+	"dotenv": {writeDotenv, true},
+	"shell":  {writeShell, true},
+	"k8s":    {writeK8sSecret, true},
+}
+
+// secretOutput is the JSON representation of a secret
+type secretOutput struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	ID          string            `json:"id"`
+	ContentType string            `json:"contentType,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Enabled     bool              `json:"enabled"`
+	NotBefore   *time.Time        `json:"notBefore,omitempty"`
+	Expires     *time.Time        `json:"expires,omitempty"`
+	Created     *time.Time        `json:"created,omitempty"`
+	Updated     *time.Time        `json:"updated,omitempty"`
+	Value       string            `json:"value,omitempty"`
+}
+
+// outputFlags holds the output flags shared by the commands that print secrets
+type outputFlags struct {
+	format       string
+	reveal       bool
+	out          string
+	fd           int
+	k8sName      string
+	k8sNamespace string
+}
+
+func (o *outputFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.format, "format", "text", "output format: text, json, dotenv, shell or k8s")
+	fs.BoolVar(&o.reveal, "reveal", false, "include secret values, requires -out or -fd")
+	fs.StringVar(&o.out, "out", "", "file to write the output to")
+	fs.IntVar(&o.fd, "fd", -1, "file descriptor to write the output to")
+	fs.StringVar(&o.k8sName, "k8s-name", "", "name of the Kubernetes Secret for the k8s format")
+	fs.StringVar(&o.k8sNamespace, "k8s-namespace", "", "namespace of the Kubernetes Secret for the k8s format")
+}
+
+// write prints the secrets in the chosen format. The text format only goes to
+// the log and never carries values, every other format goes to stdout unless
+// values are revealed, in which case an explicit destination is required.
+func (o *outputFlags) write(secrets []*azkeyvault.Secret) error {
+	f, ok := formats[o.format]
+	if !ok {
+		return fmt.Errorf("unknown output format '%s'", o.format)
+	}
+
+	if f.needsReveal && !o.reveal {
+		return fmt.Errorf("the %s format contains secret values and requires -reveal", o.format)
+	}
+
+	opts := outputOptions{
+		Reveal:       o.reveal,
+		K8sName:      o.k8sName,
+		K8sNamespace: o.k8sNamespace,
+	}
+
+	if o.format == "text" {
+		if o.reveal {
+			return fmt.Errorf("the text format is logged and never reveals values, use -format json, dotenv, shell or k8s")
+		}
+		if o.out != "" || o.fd >= 0 {
+			return fmt.Errorf("the text format is logged, -out and -fd need -format json, dotenv, shell or k8s")
+		}
+		return writeText(nil, secrets, opts)
+	}
+
+	switch {
+	case o.out != "" && o.fd >= 0:
+		return fmt.Errorf("only one of -out and -fd can be set")
+	case o.out != "":
+		// Buffer the output so the file is replaced in one step and is
+		// only ever readable by its owner, whatever mode it had before
+		var buf bytes.Buffer
+		if err := f.write(&buf, secrets, opts); err != nil {
+			return err
+		}
+		return writeFileAtomic(o.out, buf.Bytes(), 0600, -1, -1)
+	case o.fd >= 0:
+		dest := os.NewFile(uintptr(o.fd), "fd"+strconv.Itoa(o.fd))
+		defer dest.Close()
+		return f.write(dest, secrets, opts)
+	case o.reveal:
+		return fmt.Errorf("revealing secret values requires -out or -fd")
+	}
+
+	return f.write(os.Stdout, secrets, opts)
+}
+
+// writeText logs the metadata of each secret. It never includes the value.
+func writeText(w io.Writer, secrets []*azkeyvault.Secret, opts outputOptions) error {
+	for _, s := range secrets {
+		expires := "never"
+		if s.Expires != nil {
+			expires = s.Expires.Format(time.RFC3339)
+		}
+
+		contentType := s.ContentType
+		if contentType == "" {
+			contentType = "-"
+		}
+
+		log.Printf("Retrieved secret '%s' version '%s' (content type: %s, enabled: %t, expires: %s, value: %s)",
+			s.Name, s.Version, contentType, s.Enabled, expires, redacted)
+	}
+
+	return nil
+}
+
+func writeJSON(w io.Writer, secrets []*azkeyvault.Secret, opts outputOptions) error {
+	out := make([]secretOutput, 0, len(secrets))
+	for _, s := range secrets {
+		o := secretOutput{
+			Name:        s.Name,
+			Version:     s.Version,
+			ID:          s.ID,
+			ContentType: s.ContentType,
+			Tags:        s.Tags,
+			Enabled:     s.Enabled,
+			NotBefore:   s.NotBefore,
+			Expires:     s.Expires,
+			Created:     s.Created,
+			Updated:     s.Updated,
+		}
+		if opts.Reveal {
+			o.Value = s.Value
+		}
+		out = append(out, o)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+func writeDotenv(w io.Writer, secrets []*azkeyvault.Secret, opts outputOptions) error {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`)
+	for _, s := range secrets {
+		if _, err := fmt.Fprintf(w, "%s=\"%s\"\n", envName(s.Name), replacer.Replace(s.Value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeShell(w io.Writer, secrets []*azkeyvault.Secret, opts outputOptions) error {
+	for _, s := range secrets {
+		quoted := "'" + strings.Replace(s.Value, "'", `'\''`, -1) + "'"
+		if _, err := fmt.Fprintf(w, "export %s=%s\n", envName(s.Name), quoted); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeK8sSecret(w io.Writer, secrets []*azkeyvault.Secret, opts outputOptions) error {
+	name := opts.K8sName
+	if name == "" {
+		return fmt.Errorf("the k8s format requires -k8s-name")
+	}
+
+	fmt.Fprintln(w, "apiVersion: v1")
+	fmt.Fprintln(w, "kind: Secret")
+	fmt.Fprintln(w, "metadata:")
+	fmt.Fprintf(w, "  name: %s\n", name)
+	if opts.K8sNamespace != "" {
+		fmt.Fprintf(w, "  namespace: %s\n", opts.K8sNamespace)
+	}
+
+	// Record which versions went into the manifest so it can be traced back to the vault
+	fmt.Fprintln(w, "  annotations:")
+	for _, s := range sortedSecrets(secrets) {
+		fmt.Fprintf(w, "    keyvault.azure.com/%s: %s\n", s.Name, strconv.Quote(s.Version))
+	}
+
+	fmt.Fprintln(w, "type: Opaque")
+	fmt.Fprintln(w, "data:")
+	for _, s := range sortedSecrets(secrets) {
+		if _, err := fmt.Fprintf(w, "  %s: %s\n", s.Name, base64.StdEncoding.EncodeToString([]byte(s.Value))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// envName turns a secret name like db-password into DB_PASSWORD
+func envName(secretName string) string {
+	return strings.ToUpper(strings.Replace(secretName, "-", "_", -1))
+}
+
+func sortedSecrets(secrets []*azkeyvault.Secret) []*azkeyvault.Secret {
+	sorted := make([]*azkeyvault.Secret, len(secrets))
+	copy(sorted, secrets)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+)
+
+// trickyValue contains every character the formats have to escape
+const trickyValue = "it's a \"quoted\" $HOME `cmd` \\ value\nsecond line"
+
+func newTestSecret(name, value string) *azkeyvault.Secret {
+	return &azkeyvault.Secret{
+		SecretProperties: azkeyvault.SecretProperties{
+			ID:      "https://myvault.vault.azure.net/secrets/" + name + "/v1",
+			Name:    name,
+			Version: "v1",
+			Enabled: true,
+		},
+		Value: value,
+	}
+}
+
+func TestWriteDotenv(t *testing.T) {
+	var buf bytes.Buffer
+	secrets := []*azkeyvault.Secret{newTestSecret("db-password", trickyValue)}
+	if err := writeDotenv(&buf, secrets, outputOptions{Reveal: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `DB_PASSWORD="it's a \"quoted\" \$HOME ` + "`cmd`" + ` \\ value\nsecond line"` + "\n"
+	if buf.String() != want {
+		t.Errorf("writeDotenv = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteShell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	var buf bytes.Buffer
+	secrets := []*azkeyvault.Secret{newTestSecret("db-password", trickyValue)}
+	if err := writeShell(&buf, secrets, outputOptions{Reveal: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Source the script in a real shell to make sure nothing is expanded
+	out, err := exec.Command(sh, "-c", buf.String()+`printf '%s' "$DB_PASSWORD"`).Output()
+	if err != nil {
+		t.Fatalf("sourcing %q failed: %v", buf.String(), err)
+	}
+
+	if string(out) != trickyValue {
+		t.Errorf("shell value = %q, want %q", out, trickyValue)
+	}
+}
+
+func TestWriteK8sSecret(t *testing.T) {
+	var buf bytes.Buffer
+	secrets := []*azkeyvault.Secret{newTestSecret("tls-key", trickyValue), newTestSecret("api-key", "abc")}
+	opts := outputOptions{Reveal: true, K8sName: "app-secrets", K8sNamespace: "prod"}
+	if err := writeK8sSecret(&buf, secrets, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"  name: app-secrets\n",
+		"  namespace: prod\n",
+		"    keyvault.azure.com/api-key: \"v1\"\n",
+		"  api-key: " + base64.StdEncoding.EncodeToString([]byte("abc")) + "\n",
+		"  tls-key: " + base64.StdEncoding.EncodeToString([]byte(trickyValue)) + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("manifest is missing %q:\n%s", want, out)
+		}
+	}
+
+	if strings.Index(out, "  api-key:") > strings.Index(out, "  tls-key:") {
+		t.Errorf("data keys should be sorted:\n%s", out)
+	}
+
+	if err := writeK8sSecret(&buf, secrets, outputOptions{Reveal: true}); err == nil {
+		t.Error("the k8s format should require a name")
+	}
+}
+
+func TestWriteJSONRedacts(t *testing.T) {
+	for _, reveal := range []bool{false, true} {
+		var buf bytes.Buffer
+		secrets := []*azkeyvault.Secret{newTestSecret("db-password", trickyValue)}
+		if err := writeJSON(&buf, secrets, outputOptions{Reveal: reveal}); err != nil {
+			t.Fatal(err)
+		}
+
+		var out []secretOutput
+		if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+			t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+		}
+
+		want := ""
+		if reveal {
+			want = trickyValue
+		}
+		if len(out) != 1 || out[0].Name != "db-password" || out[0].Value != want {
+			t.Errorf("reveal=%v: writeJSON = %+v", reveal, out)
+		}
+	}
+}
+
+func TestOutputFlagsRejects(t *testing.T) {
+	secrets := []*azkeyvault.Secret{newTestSecret("db-password", "value")}
+	tests := []outputFlags{
+		{format: "yaml", fd: -1},
+		{format: "text", reveal: true, fd: -1},
+		{format: "text", out: "/tmp/out", fd: -1},
+		{format: "text", fd: 3},
+		{format: "dotenv", fd: -1},
+		{format: "dotenv", reveal: true, fd: -1},
+		{format: "json", reveal: true, fd: -1},
+		{format: "json", out: "/tmp/out", fd: 3},
+	}
+
+	for _, o := range tests {
+		if err := o.write(secrets); err == nil {
+			t.Errorf("%+v should be rejected", o)
+		}
+	}
+}
+
+func TestOutputFlagsWritesPrivateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An existing world readable file must not keep its mode
+	path := filepath.Join(dir, "app.env")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := outputFlags{format: "dotenv", reveal: true, out: path, fd: -1}
+	if err := o.write([]*azkeyvault.Secret{newTestSecret("db-password", "value")}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "DB_PASSWORD=\"value\"\n" {
+		t.Errorf("file content = %q", b)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+)
+
+// runSet stores a secret read from a file or generated in the container. The
+// value is never printed, only the name and version that were written.
+func runSet(args []string) error {
+	tags := tagFlags{}
+
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	name := fs.String("name", "", "name of the secret to set")
+	in := fs.String("in", "", "file to read the value from, - for stdin")
+	generate := fs.String("generate", "", "generate the value instead: password, rsa or ec")
+	length := fs.Int("length", 32, "length of a generated password")
+	alphabet := fs.String("alphabet", azkeyvault.AlphabetAlphanumeric, "characters a generated password is drawn from")
+	bits := fs.Int("bits", 2048, "size of a generated RSA key")
+	curve := fs.String("curve", "P-256", "curve of a generated EC key: P-256, P-384 or P-521")
+	contentType := fs.String("content-type", "", "content type of the secret, application/x-pem-file for generated keys by default")
+	fs.Var(tags, "tag", "tag to set on the secret as key=value, may be repeated")
+	notBefore := fs.String("not-before", "", "activation date as RFC3339 or a duration from now, e.g. 1h")
+	expires := fs.String("expires", "", "expiry date as RFC3339 or a duration from now, e.g. 2160h")
+	force := fs.Bool("force", false, "write a new version even if the secret already exists")
+	fs.Parse(args)
+
+	if *name == "" {
+		return fmt.Errorf("-name must be set")
+	}
+
+	if (*in == "") == (*generate == "") {
+		return fmt.Errorf("exactly one of -in and -generate must be set")
+	}
+
+	opts := azkeyvault.SetSecretOptions{
+		ContentType: *contentType,
+		Tags:        tags,
+		Force:       *force,
+	}
+
+	var err error
+	if opts.NotBefore, err = parseTime(*notBefore); err != nil {
+		return fmt.Errorf("invalid -not-before: %v", err)
+	}
+	if opts.Expires, err = parseTime(*expires); err != nil {
+		return fmt.Errorf("invalid -expires: %v", err)
+	}
+
+	var value string
+	switch *generate {
+	case "":
+		b, err := readInput(*in)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimSuffix(string(b), "\n")
+	case "password":
+		value, err = azkeyvault.GeneratePassword(*length, *alphabet)
+	case "rsa":
+		value, err = azkeyvault.GenerateRSAKeyPair(*bits)
+	case "ec":
+		value, err = azkeyvault.GenerateECKeyPair(*curve)
+	default:
+		return fmt.Errorf("unknown -generate '%s', use password, rsa or ec", *generate)
+	}
+	if err != nil {
+		return err
+	}
+
+	if (*generate == "rsa" || *generate == "ec") && opts.ContentType == "" {
+		opts.ContentType = azkeyvault.PEMContentType
+	}
+
+	keyClient, err := newKeyVaultClient()
+	if err != nil {
+		return err
+	}
+
+	secret, created, err := keyClient.SetSecret(context.Background(), *name, value, opts)
+	if err != nil {
+		return fmt.Errorf("failed to set secret '%s': %v", *name, err)
+	}
+
+	if !created && !secret.Enabled {
+		log.Printf("Secret '%s' already exists but is disabled, skipping. Use -force to write a new version", secret.Name)
+		return nil
+	}
+
+	if !created {
+		log.Printf("Secret '%s' already exists at version '%s', skipping. Use -force to write a new version", secret.Name, secret.Version)
+		return nil
+	}
+
+	log.Printf("Set secret '%s' version '%s'", secret.Name, secret.Version)
+	return nil
+}
+
+// parseTime reads an RFC3339 time or a duration relative to now
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		t := time.Now().Add(d).UTC()
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
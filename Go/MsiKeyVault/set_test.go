@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	if got, err := parseTime(""); got != nil || err != nil {
+		t.Errorf("parseTime(\"\") = %v, %v, want nil", got, err)
+	}
+
+	got, err := parseTime("2030-01-02T03:04:05Z")
+	if err != nil || !got.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("parseTime(RFC3339) = %v, %v", got, err)
+	}
+
+	before := time.Now()
+	got, err = parseTime("2h")
+	if err != nil {
+		t.Fatalf("parseTime(2h) failed: %v", err)
+	}
+	if d := got.Sub(before); d < 2*time.Hour || d > 2*time.Hour+time.Minute {
+		t.Errorf("parseTime(2h) is %v from now", d)
+	}
+
+	if _, err := parseTime("next week"); err == nil {
+		t.Error("parseTime should reject values that are neither a time nor a duration")
+	}
+}
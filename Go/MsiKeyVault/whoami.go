@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azcred"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+)
+
+// whoamiOutput is the JSON representation of the whoami report
+type whoamiOutput struct {
+	Method   string         `json:"method"`
+	Resource string         `json:"resource"`
+	Identity string         `json:"identity,omitempty"`
+	Claims   *azcred.Claims `json:"claims"`
+	Problems []string       `json:"problems,omitempty"`
+}
+
+// runWhoami gets a token the same way the other commands do and explains
+// which identity it belongs to. The token itself is never printed.
+func runWhoami(args []string) error {
+	fs := flag.NewFlagSet("whoami", flag.ExitOnError)
+	resource := fs.String("resource", "keyvault", "resource to get a token for: keyvault, management, storage or a resource URI")
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	env, err := azenv.FromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	chain, err := azcred.FromEnvironment(env, msiIdentity())
+	if err != nil {
+		return err
+	}
+
+	res := azenv.Resource(env, *resource)
+	token, method, err := chain.Token(context.Background(), res)
+	if err != nil {
+		return err
+	}
+
+	claims, err := azcred.DecodeClaims(token.OAuthToken())
+	if err != nil {
+		return err
+	}
+
+	out := whoamiOutput{
+		Method:   method,
+		Resource: res,
+		Claims:   claims,
+	}
+
+	// The identity only matters for MSI, other methods bring their own
+	if method == azcred.MSI {
+		identity := chain.IdentityFor(res)
+		out.Identity = identity.String()
+		out.Problems = claims.Problems(res, identity)
+	} else {
+		out.Problems = claims.Problems(res, azcred.Identity{})
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	case "text":
+		writeWhoami(out)
+	default:
+		return fmt.Errorf("unknown output format '%s'", *format)
+	}
+
+	if len(out.Problems) > 0 {
+		return fmt.Errorf("found %d problems with the token", len(out.Problems))
+	}
+
+	return nil
+}
+
+func writeWhoami(out whoamiOutput) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Credential\t%s\n", out.Method)
+	fmt.Fprintf(w, "Resource\t%s\n", out.Resource)
+	if out.Identity != "" {
+		fmt.Fprintf(w, "Requested identity\t%s\n", out.Identity)
+	}
+	fmt.Fprintf(w, "Object ID (oid)\t%s\n", orDash(out.Claims.ObjectID))
+	fmt.Fprintf(w, "App ID (appid)\t%s\n", orDash(out.Claims.AppID))
+	fmt.Fprintf(w, "Tenant ID (tid)\t%s\n", orDash(out.Claims.TenantID))
+	fmt.Fprintf(w, "Identity (xms_mirid)\t%s\n", orDash(out.Claims.ResourceID))
+	fmt.Fprintf(w, "Audience (aud)\t%s\n", orDash(out.Claims.Audience))
+	fmt.Fprintf(w, "Issuer (iss)\t%s\n", orDash(out.Claims.Issuer))
+	fmt.Fprintf(w, "Issued (iat)\t%s\n", formatTime(out.Claims.IssuedAt))
+	fmt.Fprintf(w, "Expires (exp)\t%s\n", formatTime(out.Claims.Expires))
+	w.Flush()
+
+	for _, p := range out.Problems {
+		fmt.Printf("WARNING: %s\n", p)
+	}
+}
+
+// msiIdentity returns the user assigned identity from any of the variables
+// the examples use, empty for the system assigned identity or to fall back to
+// AZURE_MSI_IDENTITY
+func msiIdentity() string {
+	for _, name := range []string{"MSI_USER_ASSIGNED_CLIENTID", "MSI_CLIENTID"} {
+		if id := os.Getenv(name); id != "" {
+			return id
+		}
+	}
+
+	return ""
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
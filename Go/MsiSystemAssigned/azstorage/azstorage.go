@@ -2,20 +2,39 @@ package azstorage
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2017-06-01/storage"
 	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/samkreter/container-instance-examples/Go/shared/azcred"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
 )
 
-var (
-	blobFormatString = `https://%s.blob.core.windows.net`
+// The ways the client can authenticate to blob storage
+const (
+	// AuthToken uses an Azure AD token for storage, the identity needs a Storage Blob Data role on the account
+	AuthToken = "token"
+	// AuthSharedKey signs requests with the account key, listing the keys through resource
+	// manager when none was given, which needs permission to list them
+	AuthSharedKey = "shared-key"
+	// AuthSAS sends requests with a SAS token that grants access by itself
+	AuthSAS = "sas"
 )
 
+// AuthModeVar selects how the client authenticates to blob storage, AuthToken by default
+const AuthModeVar = "AZURE_STORAGE_AUTH_MODE"
+
 // Client object to interact with azure storage
 type Client struct {
 	StorageAccountName   string
@@ -23,81 +42,261 @@ type Client struct {
 	SubscriptionID       string
 	DefaultBlobName      string
 	DefaultContainerName string
+	Environment          azure.Environment
+
+	// AuthMode is AuthToken, AuthSharedKey or AuthSAS, AuthToken when empty
+	AuthMode string
+
+	// BlobEndpoint overrides the cloud's blob endpoint, e.g. for Azurite or a private endpoint
+	BlobEndpoint string
+
+	// AccountKey signs requests in shared key mode, listed through resource manager when empty
+	AccountKey string
+
+	// KeyRefreshInterval is how long listed keys are used before they're listed
+	// again, DefaultKeyRefreshInterval when zero
+	KeyRefreshInterval time.Duration
+
+	// SASToken is the query string of a SAS, used in SAS mode
+	SASToken string
+
+	// SASMaxExpiry is the longest a SAS generated by the client may be valid
+	// for, DefaultSASMaxExpiry when zero
+	SASMaxExpiry time.Duration
+
+	// Credential gets the tokens for blob storage and resource manager, the
+	// system assigned identity only when nil
+	Credential *azcred.Chain
+
+	// RetryPolicy retries requests to resource manager and blob storage
+	RetryPolicy azretry.Policy
+
+	mu         sync.Mutex
+	authorizer autorest.Authorizer
+	keys       *keyCache
 }
 
-// NewClient creates a new client to interact with azure storage
+// NewClient creates a new client to interact with azure storage in the public cloud
 func NewClient(storageAccountName, resourceGroupName, subscriptionID, defaultContainerName string) (*Client, error) {
+	return NewClientWithEnvironment(azure.PublicCloud, storageAccountName, resourceGroupName, subscriptionID, defaultContainerName)
+}
+
+// NewClientWithEnvironment creates a new client to interact with azure storage
+// in the given cloud, authenticating with the credential chain configured in
+// the environment. AZURE_MSI_IDENTITY or AZURE_MSI_IDENTITIES select a user
+// assigned identity in place of the system assigned one, and
+// AZURE_STORAGE_AUTH_MODE=shared-key opts into the account keys.
+func NewClientWithEnvironment(env azure.Environment, storageAccountName, resourceGroupName, subscriptionID, defaultContainerName string) (*Client, error) {
+	authMode := AuthToken
+	if val, ok := os.LookupEnv(AuthModeVar); ok && val != "" {
+		authMode = val
+	}
+
+	if authMode != AuthToken && authMode != AuthSharedKey {
+		return nil, fmt.Errorf("invalid %s '%s', use %s or %s", AuthModeVar, authMode, AuthToken, AuthSharedKey)
+	}
+
+	chain, err := azcred.FromEnvironment(env, "")
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := azretry.FromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
 		StorageAccountName:   storageAccountName,
 		ResourceGroupName:    resourceGroupName,
 		SubscriptionID:       subscriptionID,
 		DefaultContainerName: defaultContainerName,
+		Environment:          env,
+		AuthMode:             authMode,
+		Credential:           chain,
+		RetryPolicy:          policy,
 	}, nil
 }
 
 // GetBlob downloads the specified blob contents
 func (c *Client) GetBlob(ctx context.Context, containerName, blobName string) (string, error) {
-	b := c.getBlobURL(ctx, containerName, blobName)
-
-	resp, err := b.GetBlob(ctx, azblob.BlobRange{}, azblob.BlobAccessConditions{}, false)
+	body, err := c.OpenBlob(ctx, containerName, blobName)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body().Close()
-	body, err := ioutil.ReadAll(resp.Body())
-	return string(body), err
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	return string(data), err
 }
 
-func (c *Client) getBlobURL(ctx context.Context, containerName, blobName string) azblob.BlobURL {
-	container := c.getContainerURL(ctx, containerName)
+// CheckKeyAccess checks the credential can list the account keys, which
+// the shared key mode needs
+func (c *Client) CheckKeyAccess(ctx context.Context) error {
+	_, err := c.listAccountKeys(ctx)
+	return err
+}
+
+// KeyStats returns which listed account key signs requests, false when the
+// client doesn't list the keys
+func (c *Client) KeyStats() (KeyStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keys == nil {
+		return KeyStats{}, false
+	}
+
+	return c.keys.stats(), true
+}
+
+// CheckBlobAccess checks the blob can be read without downloading it
+func (c *Client) CheckBlobAccess(ctx context.Context, containerName, blobName string) error {
+	_, _, err := c.blobProperties(ctx, containerName, blobName)
+	return err
+}
+
+func (c *Client) getBlobURL(ctx context.Context, containerName, blobName string) (azblob.BlobURL, error) {
+	container, err := c.getContainerURL(ctx, containerName)
+	if err != nil {
+		return azblob.BlobURL{}, err
+	}
+
 	blob := container.NewBlobURL(blobName)
-	return blob
+	return blob, nil
 }
 
-func (c *Client) getContainerURL(ctx context.Context, containerName string) azblob.ContainerURL {
-	key, err := c.getAccountPrimaryKey(ctx)
+func (c *Client) getContainerURL(ctx context.Context, containerName string) (azblob.ContainerURL, error) {
+	cred, err := c.blobCredential(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return azblob.ContainerURL{}, err
 	}
 
-	cred := azblob.NewSharedKeyCredential(c.StorageAccountName, key)
-	p := azblob.NewPipeline(cred, azblob.PipelineOptions{})
+	p := newPipeline(cred, c.retryPolicy())
 
-	// azblob.PipelineOptions{
-	// 	Telemetry: azblob.TelemetryOptions{Value: config.UserAgent()},
-	// }
+	u, err := url.Parse(c.Endpoint())
+	if err != nil {
+		return azblob.ContainerURL{}, fmt.Errorf("invalid blob endpoint '%s': %v", c.Endpoint(), err)
+	}
+
+	if c.AuthMode == AuthSAS {
+		u.RawQuery = strings.TrimPrefix(c.SASToken, "?")
+	}
 
-	u, _ := url.Parse(fmt.Sprintf(blobFormatString, c.StorageAccountName))
 	service := azblob.NewServiceURL(*u, p)
 	container := service.NewContainerURL(containerName)
-	return container
+	return container, nil
+}
+
+// Endpoint returns the blob service endpoint the client talks to, without any SAS token
+func (c *Client) Endpoint() string {
+	if c.BlobEndpoint != "" {
+		return strings.TrimSuffix(c.BlobEndpoint, "/")
+	}
+
+	return azenv.BlobEndpoint(c.Environment, c.StorageAccountName)
 }
 
-func (c *Client) getAccountPrimaryKey(ctx context.Context) (string, error) {
+// blobCredential returns the pipeline policy that authenticates blob requests
+// in the client's auth mode
+func (c *Client) blobCredential(ctx context.Context) (pipeline.Factory, error) {
+	switch c.AuthMode {
+	case AuthSAS:
+		return azblob.NewAnonymousCredential(), nil
+	case AuthSharedKey:
+		if c.AccountKey == "" {
+			return newKeyCredential(c.accountKeys()), nil
+		}
+
+		// azblob panics on a malformed key
+		if _, err := base64.StdEncoding.DecodeString(c.AccountKey); err != nil {
+			return nil, fmt.Errorf("the key for storage account '%s' isn't valid base64", c.StorageAccountName)
+		}
+
+		return azblob.NewSharedKeyCredential(c.StorageAccountName, c.AccountKey), nil
+	}
+
+	// Keep the authorizer so its token is reused and refreshed across requests
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.authorizer == nil {
+		chain, err := c.credential()
+		if err != nil {
+			return nil, err
+		}
+		c.authorizer = chain.Authorizer(azenv.StorageResource(c.Environment))
+	}
+
+	return newTokenCredential(c.authorizer), nil
+}
+
+// accountKeys returns the client's cache of the keys listed through resource manager
+func (c *Client) accountKeys() *keyCache {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.keys == nil {
+		interval := c.KeyRefreshInterval
+		if interval <= 0 {
+			interval = DefaultKeyRefreshInterval
+		}
+		c.keys = newKeyCache(c.StorageAccountName, interval, c.listAccountKeys)
+	}
+
+	return c.keys
+}
+
+func (c *Client) listAccountKeys(ctx context.Context) ([]accountKey, error) {
 	accountsClient, err := c.getStorageAccountsClient()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	result, err := accountsClient.ListKeys(ctx, c.ResourceGroupName, c.StorageAccountName)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	var keys []accountKey
+	if result.Keys != nil {
+		for _, key := range *result.Keys {
+			if key.KeyName != nil && key.Value != nil {
+				keys = append(keys, accountKey{name: *key.KeyName, value: *key.Value})
+			}
+		}
 	}
 
-	return *(((*result.Keys)[0]).Value), nil
+	return validKeys(keys), nil
 }
 
 func (c *Client) getStorageAccountsClient() (*storage.AccountsClient, error) {
-	storageAccountsClient := storage.NewAccountsClient(c.SubscriptionID)
+	storageAccountsClient := storage.NewAccountsClientWithBaseURI(c.Environment.ResourceManagerEndpoint, c.SubscriptionID)
 
-	msiConfig := auth.NewMSIConfig()
-
-	auth, err := msiConfig.Authorizer()
+	chain, err := c.credential()
 	if err != nil {
 		return nil, err
 	}
 
-	storageAccountsClient.Authorizer = auth
+	// Tokens are requested inside the retries, so the client's own Authorizer stays unset
+	authorizer := chain.Authorizer(azenv.ResourceManagerResource(c.Environment))
+	storageAccountsClient.Sender = autorest.DecorateSender(storageAccountsClient.Sender, azretry.AuthorizeSender(authorizer), c.retryPolicy().SendDecorator())
 	//storageAccountsClient.AddToUserAgent(config.UserAgent())
 	return &storageAccountsClient, nil
 }
+
+func (c *Client) credential() (*azcred.Chain, error) {
+	if c.Credential != nil {
+		return c.Credential, nil
+	}
+
+	return azcred.NewChain(c.Environment, azcred.Identity{}, azcred.MSI)
+}
+
+func (c *Client) retryPolicy() azretry.Policy {
+	if c.RetryPolicy == (azretry.Policy{}) {
+		return azretry.DefaultPolicy
+	}
+
+	return c.RetryPolicy
+}
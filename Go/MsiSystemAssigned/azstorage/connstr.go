@@ -0,0 +1,204 @@
+package azstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// The well known account of the Azurite and storage emulators
+const (
+	devStoreAccountName  = "devstoreaccount1"
+	devStoreAccountKey   = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	devStoreBlobEndpoint = "http://127.0.0.1:10000/devstoreaccount1"
+)
+
+// NewClientFromConnectionString creates a client from a storage connection
+// string, signing requests with its account key or sending its SAS. A
+// BlobEndpoint in the connection string, e.g. Azurite's or a private
+// endpoint, is used as is, otherwise the endpoint is built from the
+// connection string's EndpointSuffix or the cloud's. UseDevelopmentStorage=true
+// connects to a local Azurite.
+func NewClientFromConnectionString(env azure.Environment, connectionString string) (*Client, error) {
+	settings, err := parseConnectionString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(settings["usedevelopmentstorage"], "true") {
+		settings["accountname"] = devStoreAccountName
+		settings["accountkey"] = devStoreAccountKey
+		if settings["blobendpoint"] == "" {
+			settings["blobendpoint"] = devStoreBlobEndpoint
+		}
+	}
+
+	c, err := newClient(env, settings["accountname"])
+	if err != nil {
+		return nil, err
+	}
+
+	c.BlobEndpoint = settings["blobendpoint"]
+	if c.BlobEndpoint == "" {
+		if c.StorageAccountName == "" {
+			return nil, fmt.Errorf("the connection string needs an AccountName or a BlobEndpoint")
+		}
+
+		protocol := settings["defaultendpointsprotocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+
+		suffix := settings["endpointsuffix"]
+		if suffix == "" {
+			suffix = env.StorageEndpointSuffix
+		}
+
+		c.BlobEndpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, c.StorageAccountName, suffix)
+	}
+
+	switch {
+	case settings["sharedaccesssignature"] != "":
+		c.AuthMode = AuthSAS
+		c.SASToken = settings["sharedaccesssignature"]
+	case settings["accountkey"] != "":
+		if c.StorageAccountName == "" {
+			return nil, fmt.Errorf("the connection string has an AccountKey but no AccountName")
+		}
+
+		if _, err := base64.StdEncoding.DecodeString(settings["accountkey"]); err != nil {
+			return nil, fmt.Errorf("the connection string's AccountKey isn't valid base64")
+		}
+
+		c.AuthMode = AuthSharedKey
+		c.AccountKey = settings["accountkey"]
+	default:
+		return nil, fmt.Errorf("the connection string needs an AccountKey or a SharedAccessSignature")
+	}
+
+	return c, nil
+}
+
+// NewClientFromSASURL creates a client from a service or container SAS URL.
+// For a container SAS the container becomes the client's default container.
+// Path style URLs, e.g. Azurite's http://127.0.0.1:10000/devstoreaccount1,
+// keep the account name in the endpoint.
+func NewClientFromSASURL(env azure.Environment, sasURL string) (*Client, error) {
+	u, err := url.Parse(sasURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid SAS URL")
+	}
+
+	if u.Query().Get("sig") == "" {
+		return nil, fmt.Errorf("the URL for '%s' has no SAS token", u.Host)
+	}
+
+	endpoint := url.URL{Scheme: u.Scheme, Host: u.Host}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if segments[0] == "" {
+		segments = nil
+	}
+
+	accountName := strings.SplitN(u.Hostname(), ".", 2)[0]
+	if isPathStyle(u.Hostname()) {
+		if len(segments) == 0 {
+			return nil, fmt.Errorf("the SAS URL for '%s' has no account name in its path", u.Host)
+		}
+
+		accountName = segments[0]
+		endpoint.Path = "/" + accountName
+		segments = segments[1:]
+	}
+
+	if len(segments) > 1 {
+		return nil, fmt.Errorf("a blob SAS only grants access to one blob, use a service or container SAS")
+	}
+
+	c, err := newClient(env, accountName)
+	if err != nil {
+		return nil, err
+	}
+
+	c.AuthMode = AuthSAS
+	c.BlobEndpoint = endpoint.String()
+	c.SASToken = u.RawQuery
+	if len(segments) == 1 {
+		c.DefaultContainerName = segments[0]
+	}
+
+	return c, nil
+}
+
+// NewClientFromSecret creates a client from a SAS URL or a connection string
+// kept in a keyvault secret, so neither has to be passed to the container
+func NewClientFromSecret(ctx context.Context, env azure.Environment, getter azkeyvault.SecretGetter, secretRef string) (*Client, error) {
+	secret, err := getter.GetSecret(ctx, secretRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// The errors below never include the value, it holds a credential
+	value := strings.TrimSpace(secret.Value)
+	if strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://") {
+		c, err := NewClientFromSASURL(env, value)
+		if err != nil {
+			return nil, fmt.Errorf("secret '%s': %v", secretRef, err)
+		}
+		return c, nil
+	}
+
+	c, err := NewClientFromConnectionString(env, value)
+	if err != nil {
+		return nil, fmt.Errorf("secret '%s': %v", secretRef, err)
+	}
+	return c, nil
+}
+
+// newClient creates a client in the cloud that doesn't go through resource manager
+func newClient(env azure.Environment, accountName string) (*Client, error) {
+	policy, err := azretry.FromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		StorageAccountName: accountName,
+		Environment:        env,
+		RetryPolicy:        policy,
+	}, nil
+}
+
+// parseConnectionString reads the Key=Value pairs of a connection string into
+// a map with lower case keys. Values may contain '=', e.g. base64 padding.
+func parseConnectionString(connectionString string) (map[string]string, error) {
+	settings := map[string]string{}
+	for i, pair := range strings.Split(connectionString, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		idx := strings.Index(pair, "=")
+		if idx <= 0 {
+			// Don't echo the pair, it may be part of a key
+			return nil, fmt.Errorf("invalid connection string, part %d isn't Key=Value", i+1)
+		}
+
+		settings[strings.ToLower(strings.TrimSpace(pair[:idx]))] = strings.TrimSpace(pair[idx+1:])
+	}
+
+	return settings, nil
+}
+
+// isPathStyle reports whether the account name is in the path rather than the
+// host name, as for emulators reached by IP address or a bare host name like
+// localhost or a compose service
+func isPathStyle(host string) bool {
+	return net.ParseIP(host) != nil || !strings.Contains(host, ".")
+}
@@ -0,0 +1,211 @@
+package azstorage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+)
+
+const testAccountKey = "a2V5MQ=="
+
+func TestNewClientFromConnectionString(t *testing.T) {
+	tests := []struct {
+		connectionString string
+		wantAccount      string
+		wantEndpoint     string
+		wantMode         string
+		wantErr          bool
+	}{
+		{
+			connectionString: "DefaultEndpointsProtocol=https;AccountName=myaccount;AccountKey=" + testAccountKey + ";EndpointSuffix=core.windows.net",
+			wantAccount:      "myaccount",
+			wantEndpoint:     "https://myaccount.blob.core.windows.net",
+			wantMode:         AuthSharedKey,
+		},
+		{
+			connectionString: "accountname=myaccount; accountkey=" + testAccountKey + "; endpointsuffix=core.chinacloudapi.cn",
+			wantAccount:      "myaccount",
+			wantEndpoint:     "https://myaccount.blob.core.chinacloudapi.cn",
+			wantMode:         AuthSharedKey,
+		},
+		{
+			connectionString: "AccountName=myaccount;AccountKey=" + testAccountKey,
+			wantAccount:      "myaccount",
+			wantEndpoint:     "https://myaccount.blob.core.windows.net",
+			wantMode:         AuthSharedKey,
+		},
+		{
+			connectionString: "BlobEndpoint=https://myaccount.privatelink.blob.core.windows.net/;SharedAccessSignature=sv=2017-04-17&sig=abc",
+			wantEndpoint:     "https://myaccount.privatelink.blob.core.windows.net",
+			wantMode:         AuthSAS,
+		},
+		{
+			connectionString: "UseDevelopmentStorage=true",
+			wantAccount:      devStoreAccountName,
+			wantEndpoint:     devStoreBlobEndpoint,
+			wantMode:         AuthSharedKey,
+		},
+		{
+			connectionString: "UseDevelopmentStorage=true;BlobEndpoint=http://azurite:10000/devstoreaccount1",
+			wantAccount:      devStoreAccountName,
+			wantEndpoint:     "http://azurite:10000/devstoreaccount1",
+			wantMode:         AuthSharedKey,
+		},
+		{connectionString: "AccountName=myaccount", wantErr: true},
+		{connectionString: "AccountKey=" + testAccountKey, wantErr: true},
+		{connectionString: "BlobEndpoint=https://myaccount.blob.core.windows.net;AccountKey=" + testAccountKey, wantErr: true},
+		{connectionString: "AccountName=myaccount;AccountKey=not base64!", wantErr: true},
+		{connectionString: "AccountName=myaccount;secretpart", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, err := NewClientFromConnectionString(azure.PublicCloud, tt.connectionString)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewClientFromConnectionString(%q) should fail", tt.connectionString)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("NewClientFromConnectionString(%q) failed: %v", tt.connectionString, err)
+			continue
+		}
+
+		if c.StorageAccountName != tt.wantAccount || c.Endpoint() != tt.wantEndpoint || c.AuthMode != tt.wantMode {
+			t.Errorf("NewClientFromConnectionString(%q) = account %q, endpoint %q, mode %q, want %q, %q, %q",
+				tt.connectionString, c.StorageAccountName, c.Endpoint(), c.AuthMode, tt.wantAccount, tt.wantEndpoint, tt.wantMode)
+		}
+	}
+}
+
+func TestConnectionStringEnvironment(t *testing.T) {
+	c, err := NewClientFromConnectionString(azure.ChinaCloud, "AccountName=myaccount;AccountKey="+testAccountKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Endpoint() != "https://myaccount.blob.core.chinacloudapi.cn" || c.Environment.Name != azure.ChinaCloud.Name {
+		t.Errorf("a connection string without EndpointSuffix should use the cloud's, got endpoint %q in %s", c.Endpoint(), c.Environment.Name)
+	}
+
+	c, err = NewClientFromConnectionString(azure.ChinaCloud, "AccountName=myaccount;AccountKey="+testAccountKey+";EndpointSuffix=core.windows.net")
+	if err != nil || c.Endpoint() != "https://myaccount.blob.core.windows.net" {
+		t.Errorf("the connection string's EndpointSuffix should win over the cloud's, got %v, %v", c, err)
+	}
+
+	c, err = NewClientFromSASURL(azure.USGovernmentCloud, "https://myaccount.blob.core.usgovcloudapi.net/?sig=abc")
+	if err != nil || c.Environment.Name != azure.USGovernmentCloud.Name {
+		t.Errorf("NewClientFromSASURL should keep the cloud, got %v, %v", c, err)
+	}
+}
+
+func TestConnectionStringErrorsHideValues(t *testing.T) {
+	_, err := NewClientFromConnectionString(azure.PublicCloud, "AccountName=myaccount;supersecretkeymaterial")
+	if err == nil || strings.Contains(err.Error(), "supersecret") {
+		t.Errorf("the error should not echo the connection string, got %v", err)
+	}
+}
+
+func TestNewClientFromSASURL(t *testing.T) {
+	tests := []struct {
+		sasURL        string
+		wantAccount   string
+		wantEndpoint  string
+		wantContainer string
+		wantErr       bool
+	}{
+		{
+			sasURL:       "https://myaccount.blob.core.windows.net/?sv=2017-04-17&sig=abc",
+			wantAccount:  "myaccount",
+			wantEndpoint: "https://myaccount.blob.core.windows.net",
+		},
+		{
+			sasURL:        "https://myaccount.blob.core.windows.net/configs?sv=2017-04-17&sr=c&sig=abc",
+			wantAccount:   "myaccount",
+			wantEndpoint:  "https://myaccount.blob.core.windows.net",
+			wantContainer: "configs",
+		},
+		{
+			sasURL:        "http://127.0.0.1:10000/devstoreaccount1/configs?sig=abc",
+			wantAccount:   "devstoreaccount1",
+			wantEndpoint:  "http://127.0.0.1:10000/devstoreaccount1",
+			wantContainer: "configs",
+		},
+		{
+			sasURL:       "http://azurite:10000/devstoreaccount1?sig=abc",
+			wantAccount:  "devstoreaccount1",
+			wantEndpoint: "http://azurite:10000/devstoreaccount1",
+		},
+		{sasURL: "https://myaccount.blob.core.windows.net/configs/app.conf?sr=b&sig=abc", wantErr: true},
+		{sasURL: "https://myaccount.blob.core.windows.net/configs", wantErr: true},
+		{sasURL: "http://127.0.0.1:10000/?sig=abc", wantErr: true},
+		{sasURL: "not a url", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c, err := NewClientFromSASURL(azure.PublicCloud, tt.sasURL)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewClientFromSASURL(%q) should fail", tt.sasURL)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("NewClientFromSASURL(%q) failed: %v", tt.sasURL, err)
+			continue
+		}
+
+		if c.StorageAccountName != tt.wantAccount || c.Endpoint() != tt.wantEndpoint || c.DefaultContainerName != tt.wantContainer {
+			t.Errorf("NewClientFromSASURL(%q) = account %q, endpoint %q, container %q, want %q, %q, %q",
+				tt.sasURL, c.StorageAccountName, c.Endpoint(), c.DefaultContainerName, tt.wantAccount, tt.wantEndpoint, tt.wantContainer)
+		}
+
+		if c.AuthMode != AuthSAS || !strings.Contains(c.SASToken, "sig=abc") {
+			t.Errorf("NewClientFromSASURL(%q) = mode %q, token %q", tt.sasURL, c.AuthMode, c.SASToken)
+		}
+	}
+}
+
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) GetSecret(ctx context.Context, secretRef string) (*azkeyvault.Secret, error) {
+	value, ok := f[secretRef]
+	if !ok {
+		return nil, errors.New("secret not found")
+	}
+
+	return &azkeyvault.Secret{Value: value}, nil
+}
+
+func TestNewClientFromSecret(t *testing.T) {
+	secrets := fakeSecrets{
+		"sas":     " https://myaccount.blob.core.windows.net/configs?sig=abc\n",
+		"connstr": "AccountName=myaccount;AccountKey=" + testAccountKey,
+		"broken":  "AccountName=myaccount;AccountKey=supersecret!",
+	}
+
+	c, err := NewClientFromSecret(context.Background(), azure.PublicCloud, secrets, "sas")
+	if err != nil || c.AuthMode != AuthSAS || c.DefaultContainerName != "configs" {
+		t.Errorf("NewClientFromSecret(sas) = %+v, %v", c, err)
+	}
+
+	c, err = NewClientFromSecret(context.Background(), azure.PublicCloud, secrets, "connstr")
+	if err != nil || c.AuthMode != AuthSharedKey || c.StorageAccountName != "myaccount" {
+		t.Errorf("NewClientFromSecret(connstr) = %+v, %v", c, err)
+	}
+
+	_, err = NewClientFromSecret(context.Background(), azure.PublicCloud, secrets, "broken")
+	if err == nil || !strings.Contains(err.Error(), "broken") || strings.Contains(err.Error(), "supersecret") {
+		t.Errorf("the error should name the secret without its value, got %v", err)
+	}
+
+	if _, err := NewClientFromSecret(context.Background(), azure.PublicCloud, secrets, "missing"); err == nil {
+		t.Error("a missing secret should fail")
+	}
+}
@@ -0,0 +1,35 @@
+package azstorage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// tokenServiceVersion is the first storage service version that accepts
+// Azure AD tokens, the vendored azblob sends 2016-05-31
+const tokenServiceVersion = "2017-11-09"
+
+// newTokenCredential authenticates blob requests with a bearer token from the
+// authorizer, which refreshes the token before it expires. azblob's own
+// TokenCredential holds a fixed token that the caller has to keep fresh.
+func newTokenCredential(authorizer autorest.Authorizer) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			if request.URL.Scheme != "https" {
+				return nil, fmt.Errorf("token credentials need an https endpoint, got '%s'", request.URL)
+			}
+
+			r, err := autorest.Prepare(request.Request.WithContext(ctx), authorizer.WithAuthorization())
+			if err != nil {
+				return nil, err
+			}
+
+			request.Request = r
+			request.Header.Set("x-ms-version", tokenServiceVersion)
+			return next.Do(ctx, request)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package azstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// captureSender records the request that reached the wire instead of sending it
+type captureSender struct {
+	request *http.Request
+}
+
+func (s *captureSender) New(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		s.request = request.Request
+		return pipeline.NewHTTPResponse(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody}), nil
+	})
+}
+
+// send runs a request carrying azblob's service version through the credential
+func send(t *testing.T, cred pipeline.Factory, rawURL string) (*http.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := pipeline.NewRequest(http.MethodGet, *u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("x-ms-version", azblob.ServiceVersion)
+
+	sender := &captureSender{}
+	p := pipeline.NewPipeline([]pipeline.Factory{cred}, pipeline.Options{HTTPSender: sender})
+	_, err = p.Do(context.Background(), nil, request)
+	return sender.request, err
+}
+
+func TestTokenCredential(t *testing.T) {
+	authorizer := autorest.NewBearerAuthorizer(&adal.Token{AccessToken: "abc"})
+
+	req, err := send(t, newTokenCredential(authorizer), "https://account.blob.core.windows.net/container/blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("Authorization = %q, want the authorizer's token", got)
+	}
+	if got := req.Header.Get("x-ms-version"); got != tokenServiceVersion {
+		t.Errorf("x-ms-version = %q, want %q for token requests", got, tokenServiceVersion)
+	}
+
+	if _, err := send(t, newTokenCredential(authorizer), "http://account.blob.core.windows.net/container/blob"); err == nil {
+		t.Error("a token should never be sent over http")
+	}
+}
+
+func TestSharedKeyCredentialKeepsVersion(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("key"))
+
+	req, err := send(t, azblob.NewSharedKeyCredential("account", key), "https://account.blob.core.windows.net/container/blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("x-ms-version"); got != azblob.ServiceVersion {
+		t.Errorf("x-ms-version = %q, shared key requests should keep %q", got, azblob.ServiceVersion)
+	}
+}
+
+func TestNewClientAuthMode(t *testing.T) {
+	defer os.Unsetenv(AuthModeVar)
+
+	os.Unsetenv(AuthModeVar)
+	c, err := NewClientWithEnvironment(azure.PublicCloud, "account", "group", "sub", "")
+	if err != nil || c.AuthMode != AuthToken {
+		t.Errorf("the default auth mode = %v, %v, want %s", c, err, AuthToken)
+	}
+
+	os.Setenv(AuthModeVar, AuthSharedKey)
+	if c, err := NewClientWithEnvironment(azure.PublicCloud, "account", "group", "sub", ""); err != nil || c.AuthMode != AuthSharedKey {
+		t.Errorf("%s=%s gave %v, %v", AuthModeVar, AuthSharedKey, c, err)
+	}
+
+	os.Setenv(AuthModeVar, "sas")
+	if _, err := NewClientWithEnvironment(azure.PublicCloud, "account", "group", "sub", ""); err == nil {
+		t.Error("an unknown auth mode should be refused")
+	}
+}
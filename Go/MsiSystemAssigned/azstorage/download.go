@@ -0,0 +1,352 @@
+package azstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+const (
+	// DefaultBlockSize is the size of each ranged request of a download
+	DefaultBlockSize = 4 * 1024 * 1024
+	// DefaultDownloadConcurrency is the number of ranges downloaded at once
+	DefaultDownloadConcurrency = 8
+
+	// stateSaveInterval bounds how often the progress of a file download is saved
+	stateSaveInterval = time.Second * 5
+)
+
+// DownloadOptions controls how a blob is split into ranged requests
+type DownloadOptions struct {
+	// BlockSize is the size of each range, DefaultBlockSize when zero. Each
+	// range in flight is held in memory.
+	BlockSize int64
+	// Concurrency is the number of ranges downloaded at once, DefaultDownloadConcurrency when zero
+	Concurrency int
+	// Progress is called after every range with the bytes downloaded so far and the blob's size
+	Progress func(downloaded, total int64)
+	// PartialPath is where DownloadBlobToFile writes the ranges until the
+	// download is complete, with its progress in PartialPath.json. It has to
+	// be on the same file system as the destination, path.partial when empty.
+	PartialPath string
+}
+
+func (o DownloadOptions) withDefaults() DownloadOptions {
+	if o.BlockSize <= 0 {
+		o.BlockSize = DefaultBlockSize
+	}
+
+	if o.Concurrency <= 0 {
+		o.Concurrency = DefaultDownloadConcurrency
+	}
+
+	return o
+}
+
+// OpenBlob streams a blob's contents with a single request. The caller closes the reader.
+func (c *Client) OpenBlob(ctx context.Context, containerName, blobName string) (io.ReadCloser, error) {
+	body, _, err := c.OpenBlobIf(ctx, containerName, blobName, BlobConditions{})
+	return body, err
+}
+
+// DownloadBlob writes a blob to w with parallel ranged requests and returns
+// its size. A failed range is retried on its own, and every range is pinned to
+// the blob's ETag so a blob that changes halfway fails the download instead of
+// mixing versions.
+func (c *Client) DownloadBlob(ctx context.Context, containerName, blobName string, w io.WriterAt, opts DownloadOptions) (int64, error) {
+	opts = opts.withDefaults()
+
+	b, props, err := c.blobProperties(ctx, containerName, blobName)
+	if err != nil {
+		return 0, err
+	}
+
+	size := props.ContentLength()
+	done := make([]bool, numBlocks(size, opts.BlockSize))
+	if err := c.downloadBlocks(ctx, b, props.ETag(), size, w, opts, done, nil); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// DownloadBlobToFile downloads a blob into a file with parallel ranged
+// requests. The ranges are written to path.partial, or opts.PartialPath, and
+// tracked in path.partial.json, so an interrupted download picks up where it
+// stopped as long as the blob hasn't changed. The file is moved into place
+// once complete.
+func (c *Client) DownloadBlobToFile(ctx context.Context, containerName, blobName, path string, opts DownloadOptions) (int64, error) {
+	opts = opts.withDefaults()
+
+	b, props, err := c.blobProperties(ctx, containerName, blobName)
+	if err != nil {
+		return 0, err
+	}
+
+	size, etag := props.ContentLength(), props.ETag()
+	partialPath := opts.PartialPath
+	if partialPath == "" {
+		partialPath = path + ".partial"
+	}
+	statePath := partialPath + ".json"
+
+	state, err := loadDownloadState(statePath)
+	if err != nil {
+		return 0, err
+	}
+
+	// The saved progress only holds for the blob version and partial file it was saved with
+	flags := os.O_RDWR | os.O_CREATE
+	if state == nil || !state.matches(etag, size, opts.BlockSize) || !partialMatches(partialPath, size) {
+		state = &downloadState{ETag: string(etag), Size: size, BlockSize: opts.BlockSize, Done: make([]bool, numBlocks(size, opts.BlockSize))}
+		flags |= os.O_TRUNC
+	}
+
+	for _, d := range []string{filepath.Dir(path), filepath.Dir(partialPath)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return 0, err
+		}
+	}
+
+	f, err := os.OpenFile(partialPath, flags, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(size); err != nil {
+		return 0, err
+	}
+
+	// Only ranges that reached the disk are recorded as done
+	save := func() error {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		return state.save(statePath)
+	}
+
+	var lastSave time.Time
+	onDone := func(i int) error {
+		state.Done[i] = true
+		if time.Since(lastSave) < stateSaveInterval {
+			return nil
+		}
+		lastSave = time.Now()
+		return save()
+	}
+
+	if err := c.downloadBlocks(ctx, b, etag, size, f, opts, state.Done, onDone); err != nil {
+		if saveErr := save(); saveErr != nil {
+			return 0, fmt.Errorf("%v, and failed to save the download's progress: %v", err, saveErr)
+		}
+		return 0, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(partialPath, path); err != nil {
+		return 0, err
+	}
+
+	os.Remove(statePath)
+	return size, nil
+}
+
+func (c *Client) blobProperties(ctx context.Context, containerName, blobName string) (azblob.BlobURL, *azblob.BlobsGetPropertiesResponse, error) {
+	b, err := c.getBlobURL(ctx, containerName, blobName)
+	if err != nil {
+		return azblob.BlobURL{}, nil, err
+	}
+
+	props, err := b.GetPropertiesAndMetadata(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return azblob.BlobURL{}, nil, err
+	}
+
+	return b, props, nil
+}
+
+// downloadBlocks downloads the blocks that aren't done yet into w. onDone is
+// called for every finished block, never concurrently.
+func (c *Client) downloadBlocks(ctx context.Context, b azblob.BlobURL, etag azblob.ETag, size int64, w io.WriterAt, opts DownloadOptions, done []bool, onDone func(i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	skip := make([]bool, len(done))
+	copy(skip, done)
+
+	var downloaded int64
+	for i := range skip {
+		if skip[i] {
+			downloaded += blockLength(i, size, opts.BlockSize)
+		}
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(downloaded, size)
+	}
+
+	policy := c.retryPolicy()
+	sem := make(chan struct{}, opts.Concurrency)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for i := range skip {
+		if skip[i] {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			offset, count := int64(i)*opts.BlockSize, blockLength(i, size, opts.BlockSize)
+			err := policy.Do(ctx, fmt.Sprintf("get range %d-%d", offset, offset+count-1), func(ctx context.Context) error {
+				return downloadRange(ctx, b, etag, offset, count, w)
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			downloaded += count
+			if onDone != nil {
+				if err := onDone(i); err != nil {
+					fail(err)
+					return
+				}
+			}
+
+			if opts.Progress != nil {
+				opts.Progress(downloaded, size)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// downloadRange reads one range fully before writing it, so a response that
+// stops halfway can be retried without leaving a torn range behind
+func downloadRange(ctx context.Context, b azblob.BlobURL, etag azblob.ETag, offset, count int64, w io.WriterAt) error {
+	ac := azblob.BlobAccessConditions{HTTPAccessConditions: azblob.HTTPAccessConditions{IfMatch: etag}}
+	resp, err := b.GetBlob(ctx, azblob.BlobRange{Offset: offset, Count: count}, ac, false)
+	if err != nil {
+		// The pipeline retried the request, only a body that stops halfway is retried here
+		return azretry.MarkPermanent(err)
+	}
+	defer resp.Body().Close()
+
+	buf := make([]byte, count)
+	if _, err := io.ReadFull(resp.Body(), buf); err != nil {
+		return azretry.MarkTransient(err)
+	}
+
+	_, err = w.WriteAt(buf, offset)
+	return err
+}
+
+func numBlocks(size, blockSize int64) int {
+	return int((size + blockSize - 1) / blockSize)
+}
+
+func blockLength(i int, size, blockSize int64) int64 {
+	if rest := size - int64(i)*blockSize; rest < blockSize {
+		return rest
+	}
+
+	return blockSize
+}
+
+// downloadState records which blocks of a file download are on disk
+type downloadState struct {
+	ETag      string `json:"etag"`
+	Size      int64  `json:"size"`
+	BlockSize int64  `json:"block_size"`
+	Done      []bool `json:"done"`
+}
+
+// loadDownloadState reads the state of an earlier download, nil if there is none
+func loadDownloadState(path string) (*downloadState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// A damaged state file only means starting over
+	state := &downloadState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, nil
+	}
+
+	return state, nil
+}
+
+func (s *downloadState) matches(etag azblob.ETag, size, blockSize int64) bool {
+	return s.ETag == string(etag) && s.Size == size && s.BlockSize == blockSize && len(s.Done) == numBlocks(size, blockSize)
+}
+
+// partialMatches reports whether the partial file of an earlier download is
+// still there with the blob's size, it's missing when it was removed and
+// shorter when it was replaced
+func partialMatches(path string, size int64) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == size
+}
+
+// save writes the state to a temp file and renames it into place, so a crash
+// never leaves a half written state behind
+func (s *downloadState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,274 @@
+package azstorage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+)
+
+func TestBlocks(t *testing.T) {
+	tests := []struct {
+		size, blockSize int64
+		blocks          int
+		last            int64
+	}{
+		{size: 10, blockSize: 4, blocks: 3, last: 2},
+		{size: 8, blockSize: 4, blocks: 2, last: 4},
+		{size: 3, blockSize: 4, blocks: 1, last: 3},
+		{size: 0, blockSize: 4, blocks: 0},
+	}
+
+	for _, tt := range tests {
+		if n := numBlocks(tt.size, tt.blockSize); n != tt.blocks {
+			t.Errorf("numBlocks(%d, %d) = %d, want %d", tt.size, tt.blockSize, n, tt.blocks)
+		}
+
+		if tt.blocks == 0 {
+			continue
+		}
+
+		if l := blockLength(tt.blocks-1, tt.size, tt.blockSize); l != tt.last {
+			t.Errorf("blockLength of the last block of %d = %d, want %d", tt.size, l, tt.last)
+		}
+	}
+}
+
+func TestDownloadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.bin.partial.json")
+
+	state, err := loadDownloadState(path)
+	if err != nil || state != nil {
+		t.Fatalf("loadDownloadState without a file = %+v, %v, want nil", state, err)
+	}
+
+	saved := &downloadState{ETag: "0x1", Size: 10, BlockSize: 4, Done: []bool{true, false, true}}
+	if err := saved.save(path); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	state, err = loadDownloadState(path)
+	if err != nil || state == nil {
+		t.Fatalf("loadDownloadState = %+v, %v", state, err)
+	}
+
+	tests := []struct {
+		etag            string
+		size, blockSize int64
+		want            bool
+	}{
+		{"0x1", 10, 4, true},
+		{"0x2", 10, 4, false},
+		{"0x1", 11, 4, false},
+		{"0x1", 10, 2, false},
+		{"0x1", 14, 4, false},
+	}
+
+	for _, tt := range tests {
+		if got := state.matches(azblob.ETag(tt.etag), tt.size, tt.blockSize); got != tt.want {
+			t.Errorf("matches(%s, %d, %d) = %v, want %v", tt.etag, tt.size, tt.blockSize, got, tt.want)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err = loadDownloadState(path)
+	if err != nil || state != nil {
+		t.Errorf("a damaged state should load as nil, got %+v, %v", state, err)
+	}
+}
+
+// blobServer serves a single blob to ranged requests and records the ranges asked for
+type blobServer struct {
+	data []byte
+	etag string
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (s *blobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("ETag", s.etag)
+	w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+		return
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" && match != s.etag {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end); err != nil {
+		w.Write(s.data)
+		return
+	}
+
+	s.mu.Lock()
+	s.ranges = append(s.ranges, fmt.Sprintf("%d-%d", start, end))
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(s.data[start : end+1])
+}
+
+func newTestBlobClient(url string) *Client {
+	return &Client{StorageAccountName: "devstoreaccount1", AuthMode: AuthSAS, BlobEndpoint: url + "/devstoreaccount1", SASToken: "sig=abc"}
+}
+
+func TestDownloadBlobToFileResumes(t *testing.T) {
+	server := &blobServer{data: []byte("0123456789"), etag: `"0x1"`}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.bin")
+
+	// An earlier run got the first and last block
+	if err := ioutil.WriteFile(path+".partial", []byte("0123\x00\x00\x00\x0089"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := &downloadState{ETag: server.etag, Size: 10, BlockSize: 4, Done: []bool{true, false, true}}
+	if err := state.save(path + ".partial.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestBlobClient(ts.URL)
+	size, err := c.DownloadBlobToFile(context.Background(), "configs", "a.bin", path, DownloadOptions{BlockSize: 4})
+	if err != nil {
+		t.Fatalf("DownloadBlobToFile failed: %v", err)
+	}
+
+	if size != 10 {
+		t.Errorf("size = %d, want 10", size)
+	}
+
+	if strings.Join(server.ranges, ",") != "4-7" {
+		t.Errorf("requested ranges %v, want only the missing block 4-7", server.ranges)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(data, server.data) {
+		t.Errorf("downloaded %q, %v, want %q", data, err, server.data)
+	}
+
+	for _, leftover := range []string{path + ".partial", path + ".partial.json"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s should be gone after the download", leftover)
+		}
+	}
+}
+
+func TestDownloadBlobToFileRestartsWhenBlobChanged(t *testing.T) {
+	server := &blobServer{data: []byte("abcdefghij"), etag: `"0x2"`}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.bin")
+	if err := ioutil.WriteFile(path+".partial", []byte("0123\x00\x00\x00\x0089"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := &downloadState{ETag: `"0x1"`, Size: 10, BlockSize: 4, Done: []bool{true, false, true}}
+	if err := state.save(path + ".partial.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestBlobClient(ts.URL)
+	if _, err := c.DownloadBlobToFile(context.Background(), "configs", "a.bin", path, DownloadOptions{BlockSize: 4}); err != nil {
+		t.Fatalf("DownloadBlobToFile failed: %v", err)
+	}
+
+	if len(server.ranges) != 3 {
+		t.Errorf("requested ranges %v, want every block again", server.ranges)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.Equal(data, server.data) {
+		t.Errorf("downloaded %q, %v, want %q", data, err, server.data)
+	}
+}
+
+func TestDownloadBlobToFileRestartsWhenPartialChanged(t *testing.T) {
+	tests := []struct {
+		name    string
+		partial []byte
+	}{
+		{"partial file removed", nil},
+		{"partial file replaced", []byte("0123")},
+	}
+
+	for _, tt := range tests {
+		server := &blobServer{data: []byte("0123456789"), etag: `"0x1"`}
+		ts := httptest.NewServer(server)
+
+		dir, err := ioutil.TempDir("", "download")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, "a.bin")
+		if tt.partial != nil {
+			if err := ioutil.WriteFile(path+".partial", tt.partial, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The state still claims the first and last block are on disk
+		state := &downloadState{ETag: server.etag, Size: 10, BlockSize: 4, Done: []bool{true, false, true}}
+		if err := state.save(path + ".partial.json"); err != nil {
+			t.Fatal(err)
+		}
+
+		c := newTestBlobClient(ts.URL)
+		if _, err := c.DownloadBlobToFile(context.Background(), "configs", "a.bin", path, DownloadOptions{BlockSize: 4}); err != nil {
+			t.Errorf("%s: DownloadBlobToFile failed: %v", tt.name, err)
+		}
+
+		if len(server.ranges) != 3 {
+			t.Errorf("%s: requested ranges %v, want every block again", tt.name, server.ranges)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil || !bytes.Equal(data, server.data) {
+			t.Errorf("%s: downloaded %q, %v, want %q", tt.name, data, err, server.data)
+		}
+
+		ts.Close()
+		os.RemoveAll(dir)
+	}
+}
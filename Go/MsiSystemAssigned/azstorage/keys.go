@@ -0,0 +1,287 @@
+package azstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// DefaultKeyRefreshInterval is how long listed account keys are used before
+// they're listed again
+const DefaultKeyRefreshInterval = time.Minute * 15
+
+// keyListRetryInterval is how long the cached keys are kept using when
+// listing them fails, before listing is tried again
+const keyListRetryInterval = time.Minute
+
+// KeyStats tells which account key signs requests. Once the requests only
+// use one key the other one can be rotated.
+type KeyStats struct {
+	Account   string           `json:"account"`
+	InUse     string           `json:"in_use"`
+	Requests  map[string]int64 `json:"requests"`
+	Failovers int64            `json:"failovers"`
+	ListedAt  time.Time        `json:"listed_at"`
+}
+
+// keyStats publishes the stats of every account at /debug/vars
+var keyStats = expvar.NewMap("azstorage_keys")
+
+type accountKey struct {
+	name  string
+	value string
+}
+
+// keyCache keeps the account keys listed through resource manager until the
+// refresh interval passes or the key in use is rejected. The keys are listed
+// without holding the lock, once for every request that needs them at the
+// same time.
+type keyCache struct {
+	account  string
+	interval time.Duration
+	list     func(ctx context.Context) ([]accountKey, error)
+
+	mu        sync.Mutex
+	keys      []accountKey
+	listedAt  time.Time
+	inUse     accountKey
+	requests  map[string]int64
+	failovers int64
+	listing   *keyListing
+}
+
+// keyListing is a listing of the keys in flight, done is closed once err is set
+type keyListing struct {
+	done chan struct{}
+	err  error
+}
+
+func newKeyCache(account string, interval time.Duration, list func(ctx context.Context) ([]accountKey, error)) *keyCache {
+	k := &keyCache{
+		account:  account,
+		interval: interval,
+		list:     list,
+		requests: map[string]int64{},
+	}
+
+	keyStats.Set(account, expvar.Func(func() interface{} { return k.stats() }))
+	return k
+}
+
+// current returns the key in use, listing the keys when they're older than
+// the refresh interval. Requests keep signing with the cached keys while
+// another request lists them.
+func (k *keyCache) current(ctx context.Context) (accountKey, error) {
+	k.mu.Lock()
+	stale := len(k.keys) == 0 || time.Since(k.listedAt) >= k.interval
+	if stale && (len(k.keys) == 0 || k.listing == nil) {
+		k.mu.Unlock()
+		err := k.refresh(ctx)
+		k.mu.Lock()
+
+		if err != nil {
+			if len(k.keys) == 0 {
+				k.mu.Unlock()
+				return accountKey{}, err
+			}
+
+			// Keep signing with the keys we have, they're most likely still valid
+			log.Printf("Failed to list the keys of storage account '%s', using the cached keys: %v", k.account, err)
+			k.listedAt = time.Now().Add(keyListRetryInterval - k.interval)
+		}
+	}
+	defer k.mu.Unlock()
+
+	k.requests[k.inUse.name]++
+	return k.inUse, nil
+}
+
+// failover lists the keys again and switches to one that differs from the
+// rejected key, the same key regenerated or the other key
+func (k *keyCache) failover(ctx context.Context, rejected accountKey) (accountKey, error) {
+	k.mu.Lock()
+
+	// Another request may have switched already
+	if k.inUse != rejected {
+		k.requests[k.inUse.name]++
+		k.mu.Unlock()
+		return k.inUse, nil
+	}
+	failovers := k.failovers
+	k.mu.Unlock()
+
+	err := k.refresh(ctx)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err != nil {
+		return accountKey{}, err
+	}
+
+	// Another request switched while the keys were listed
+	if k.failovers != failovers && k.inUse.value != rejected.value {
+		k.requests[k.inUse.name]++
+		return k.inUse, nil
+	}
+
+	for _, key := range append([]accountKey{k.inUse}, k.keys...) {
+		if key.value != rejected.value {
+			log.Printf("Storage account '%s' rejected key '%s', switching to '%s'", k.account, rejected.name, key.name)
+			k.inUse = key
+			k.failovers++
+			k.requests[key.name]++
+			return key, nil
+		}
+	}
+
+	return accountKey{}, fmt.Errorf("storage account '%s' rejected key '%s' and has no other key", k.account, rejected.name)
+}
+
+// refresh lists the keys, keeping the key in use by name if it's still there.
+// Requests that need the keys while they're being listed wait for the same
+// listing. The caller must not hold the lock.
+func (k *keyCache) refresh(ctx context.Context) error {
+	k.mu.Lock()
+	if listing := k.listing; listing != nil {
+		k.mu.Unlock()
+
+		select {
+		case <-listing.done:
+			return listing.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	listing := &keyListing{done: make(chan struct{})}
+	k.listing = listing
+	k.mu.Unlock()
+
+	keys, err := k.list(ctx)
+
+	k.mu.Lock()
+	listing.err = k.update(keys, err)
+	k.listing = nil
+	k.mu.Unlock()
+
+	close(listing.done)
+	return listing.err
+}
+
+// update switches to the listed keys, the caller holds the lock
+func (k *keyCache) update(keys []accountKey, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("storage account '%s' has no keys", k.account)
+	}
+
+	inUse := keys[0]
+	for _, key := range keys {
+		if key.name == k.inUse.name {
+			inUse = key
+		}
+	}
+
+	if inUse.name != k.inUse.name {
+		log.Printf("Using key '%s' for storage account '%s'", inUse.name, k.account)
+	}
+
+	k.keys = keys
+	k.inUse = inUse
+	k.listedAt = time.Now()
+	return nil
+}
+
+func (k *keyCache) stats() KeyStats {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	requests := make(map[string]int64, len(k.requests))
+	for name, n := range k.requests {
+		requests[name] = n
+	}
+
+	return KeyStats{
+		Account:   k.account,
+		InUse:     k.inUse.name,
+		Requests:  requests,
+		Failovers: k.failovers,
+		ListedAt:  k.listedAt,
+	}
+}
+
+// newKeyCredential signs blob requests with the key in use. When the service
+// rejects the key, e.g. halfway through a rotation, the keys are listed again
+// and the request is sent once more with the other key.
+func newKeyCredential(keys *keyCache) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			// Listing the keys went through resource manager's retries already
+			key, err := keys.current(ctx)
+			if err != nil {
+				return nil, azretry.MarkPermanent(err)
+			}
+
+			response, err := signWith(keys.account, key, next, po).Do(ctx, request)
+			if !isAuthenticationFailure(err) {
+				return response, err
+			}
+
+			other, failoverErr := keys.failover(ctx, key)
+			if failoverErr != nil {
+				log.Printf("No key to fall back to: %v", failoverErr)
+				return response, err
+			}
+
+			retry := request.Copy()
+			if err := retry.RewindBody(); err != nil {
+				return nil, err
+			}
+
+			if response != nil {
+				azretry.Drain(response.Response())
+			}
+			return signWith(keys.account, other, next, po).Do(ctx, retry)
+		}
+	})
+}
+
+func signWith(account string, key accountKey, next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.Policy {
+	return azblob.NewSharedKeyCredential(account, key.value).New(next, po)
+}
+
+// isAuthenticationFailure reports whether the service rejected the request's signature
+func isAuthenticationFailure(err error) bool {
+	se, ok := err.(azblob.StorageError)
+	if !ok || se.Response() == nil || se.Response().StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	code := azblob.ServiceCodeType(se.Response().Header.Get("x-ms-error-code"))
+	return se.ServiceCode() == azblob.ServiceCodeAuthenticationFailed || code == azblob.ServiceCodeAuthenticationFailed
+}
+
+// validKeys drops keys azblob can't sign with, it panics on malformed ones
+func validKeys(keys []accountKey) []accountKey {
+	var valid []accountKey
+	for _, key := range keys {
+		if _, err := base64.StdEncoding.DecodeString(key.value); err == nil {
+			valid = append(valid, key)
+		}
+	}
+
+	return valid
+}
@@ -0,0 +1,234 @@
+package azstorage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	key1 = accountKey{name: "key1", value: "a2V5MQ=="}
+	key2 = accountKey{name: "key2", value: "a2V5Mg=="}
+)
+
+// fakeKeys answers listings with the keys it holds and counts them
+type fakeKeys struct {
+	mu     sync.Mutex
+	keys   []accountKey
+	err    error
+	lists  int
+	listed chan struct{}
+	block  chan struct{}
+}
+
+func (f *fakeKeys) set(keys []accountKey, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys, f.err = keys, err
+}
+
+func (f *fakeKeys) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lists
+}
+
+func (f *fakeKeys) list(ctx context.Context) ([]accountKey, error) {
+	f.mu.Lock()
+	f.lists++
+	keys, err, listed, block := f.keys, f.err, f.listed, f.block
+	f.mu.Unlock()
+
+	if listed != nil {
+		listed <- struct{}{}
+	}
+	if block != nil {
+		<-block
+	}
+
+	return keys, err
+}
+
+func TestKeyCacheCurrent(t *testing.T) {
+	f := &fakeKeys{keys: []accountKey{key1, key2}}
+	k := newKeyCache("test-current", time.Hour, f.list)
+
+	for i := 0; i < 3; i++ {
+		key, err := k.current(context.Background())
+		if err != nil {
+			t.Fatalf("current failed: %v", err)
+		}
+		if key != key1 {
+			t.Errorf("current = %s, want %s", key.name, key1.name)
+		}
+	}
+
+	if f.count() != 1 {
+		t.Errorf("listed the keys %d times, want 1", f.count())
+	}
+
+	if stats := k.stats(); stats.InUse != "key1" || stats.Requests["key1"] != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestKeyCacheCurrentListFailure(t *testing.T) {
+	f := &fakeKeys{err: errors.New("forbidden")}
+	k := newKeyCache("test-list-failure", time.Hour, f.list)
+
+	if _, err := k.current(context.Background()); err == nil {
+		t.Fatal("current should fail without any keys")
+	}
+
+	f.set(nil, nil)
+	if _, err := k.current(context.Background()); err == nil {
+		t.Fatal("current should fail when the account has no keys")
+	}
+
+	// Expired keys keep being used when listing them fails
+	f.set([]accountKey{key1, key2}, nil)
+	if _, err := k.current(context.Background()); err != nil {
+		t.Fatalf("current failed: %v", err)
+	}
+
+	k.listedAt = time.Now().Add(-time.Hour * 2)
+	f.set(nil, errors.New("throttled"))
+
+	key, err := k.current(context.Background())
+	if err != nil || key != key1 {
+		t.Errorf("current = %s, %v, want the cached key", key.name, err)
+	}
+
+	if lists := f.count(); lists != 4 {
+		t.Errorf("listed the keys %d times, want 4", lists)
+	}
+
+	if _, err := k.current(context.Background()); err != nil || f.count() != 4 {
+		t.Errorf("the keys should only be listed again after %s", keyListRetryInterval)
+	}
+}
+
+func TestKeyCacheCurrentDoesNotWaitForListing(t *testing.T) {
+	f := &fakeKeys{keys: []accountKey{key1, key2}}
+	k := newKeyCache("test-listing", time.Hour, f.list)
+
+	if _, err := k.current(context.Background()); err != nil {
+		t.Fatalf("current failed: %v", err)
+	}
+
+	k.mu.Lock()
+	k.listedAt = time.Now().Add(-time.Hour * 2)
+	k.mu.Unlock()
+
+	f.mu.Lock()
+	f.listed, f.block = make(chan struct{}), make(chan struct{})
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		k.current(context.Background())
+	}()
+	<-f.listed
+
+	// The listing is blocked, the cached key is used in the meantime
+	key, err := k.current(context.Background())
+	if err != nil || key != key1 {
+		t.Errorf("current = %s, %v, want the cached key", key.name, err)
+	}
+
+	if s := k.stats(); s.Account == "" {
+		t.Error("stats should not wait for the listing")
+	}
+
+	close(f.block)
+	<-done
+
+	if f.count() != 2 {
+		t.Errorf("listed the keys %d times, want 2", f.count())
+	}
+}
+
+func TestKeyCacheFailover(t *testing.T) {
+	regenerated := accountKey{name: "key1", value: "bmV3MQ=="}
+
+	tests := []struct {
+		name    string
+		listed  []accountKey
+		want    accountKey
+		wantErr bool
+	}{
+		{"other key", []accountKey{key1, key2}, key2, false},
+		{"regenerated", []accountKey{regenerated, key2}, regenerated, false},
+		{"only key", []accountKey{key1}, accountKey{}, true},
+	}
+
+	for _, tt := range tests {
+		f := &fakeKeys{keys: []accountKey{key1, key2}}
+		k := newKeyCache("test-failover", time.Hour, f.list)
+
+		rejected, err := k.current(context.Background())
+		if err != nil {
+			t.Fatalf("%s: current failed: %v", tt.name, err)
+		}
+
+		f.set(tt.listed, nil)
+		key, err := k.failover(context.Background(), rejected)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: failover = %s, want an error", tt.name, key.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: failover failed: %v", tt.name, err)
+			continue
+		}
+
+		if key != tt.want {
+			t.Errorf("%s: failover = %+v, want %+v", tt.name, key, tt.want)
+		}
+
+		if stats := k.stats(); stats.Failovers != 1 || stats.InUse != tt.want.name {
+			t.Errorf("%s: stats = %+v", tt.name, stats)
+		}
+
+		if current, _ := k.current(context.Background()); current != tt.want {
+			t.Errorf("%s: current after failover = %+v, want %+v", tt.name, current, tt.want)
+		}
+	}
+}
+
+func TestKeyCacheConcurrentFailover(t *testing.T) {
+	f := &fakeKeys{keys: []accountKey{key1, key2}}
+	k := newKeyCache("test-concurrent-failover", time.Hour, f.list)
+
+	rejected, err := k.current(context.Background())
+	if err != nil {
+		t.Fatalf("current failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	keys := make([]accountKey, 8)
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], _ = k.failover(context.Background(), rejected)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if key != key2 {
+			t.Errorf("failover %d = %+v, want %+v", i, key, key2)
+		}
+	}
+
+	if stats := k.stats(); stats.Failovers != 1 {
+		t.Errorf("failed over %d times, want 1", stats.Failovers)
+	}
+}
@@ -0,0 +1,101 @@
+package azstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+)
+
+// ListOptions selects the blobs of a container to list
+type ListOptions struct {
+	// Prefix only lists names that start with it, e.g. "logs/2018/"
+	Prefix string
+	// Delimiter groups the names below the prefix into virtual directories,
+	// usually "/". Everything is listed flat when empty.
+	Delimiter string
+	// PageSize is the most names a single request returns, the service's
+	// default of 5000 when zero
+	PageSize int32
+}
+
+// BlobItem is a blob or, when listing with a delimiter, a virtual directory.
+// A directory only has a name, ending in the delimiter.
+type BlobItem struct {
+	Name         string            `json:"name"`
+	Dir          bool              `json:"dir,omitempty"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag,omitempty"`
+	LastModified time.Time         `json:"lastModified"`
+	ContentType  string            `json:"contentType,omitempty"`
+	ContentMD5   []byte            `json:"contentMD5,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// ListBlobs lists the blobs and virtual directories of a container in name
+// order with their properties and metadata, following the continuation
+// markers until the listing is complete
+func (c *Client) ListBlobs(ctx context.Context, containerName string, opts ListOptions) ([]BlobItem, error) {
+	container, err := c.getContainerURL(ctx, containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	listOpts := azblob.ListBlobsOptions{
+		Details:    azblob.BlobListingDetails{Metadata: true},
+		Prefix:     opts.Prefix,
+		Delimiter:  opts.Delimiter,
+		MaxResults: opts.PageSize,
+	}
+
+	var items []BlobItem
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		page, err := container.ListBlobs(ctx, marker, listOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, prefix := range page.Blobs.BlobPrefix {
+			items = append(items, BlobItem{Name: prefix.Name, Dir: true})
+		}
+
+		for _, blob := range page.Blobs.Blob {
+			items = append(items, newBlobItem(blob))
+		}
+
+		marker = page.NextMarker
+	}
+
+	// The service returns the blobs and the directories of a page apart
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+func newBlobItem(blob azblob.Blob) BlobItem {
+	props := blob.Properties
+	item := BlobItem{
+		Name:         blob.Name,
+		ETag:         string(props.Etag),
+		LastModified: props.LastModified,
+		Metadata:     blob.Metadata,
+	}
+
+	if props.ContentLength != nil {
+		item.Size = *props.ContentLength
+	}
+
+	if props.ContentType != nil {
+		item.ContentType = *props.ContentType
+	}
+
+	// Blobs committed from blocks without a Content-MD5 have none
+	if props.ContentMD5 != nil {
+		if sum, err := base64.StdEncoding.DecodeString(*props.ContentMD5); err == nil {
+			item.ContentMD5 = sum
+		}
+	}
+
+	return item
+}
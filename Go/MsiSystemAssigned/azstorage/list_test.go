@@ -0,0 +1,160 @@
+package azstorage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listServer answers container listings from a set of blob names, a page of
+// names at a time, the way the service groups them by prefix and delimiter
+type listServer struct {
+	names []string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *listServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("comp") != "list" || q.Get("restype") != "container" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.RawQuery)
+	s.mu.Unlock()
+
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	var entries []string
+	seen := map[string]bool{}
+	for _, name := range s.names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if delimiter != "" {
+			if idx := strings.Index(name[len(prefix):], delimiter); idx >= 0 {
+				name = name[:len(prefix)+idx+len(delimiter)]
+			}
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			entries = append(entries, name)
+		}
+	}
+	sort.Strings(entries)
+
+	start, _ := strconv.Atoi(q.Get("marker"))
+	end := len(entries)
+	if max, err := strconv.Atoi(q.Get("maxresults")); err == nil && start+max < end {
+		end = start + max
+	}
+
+	var blobs, prefixes string
+	for _, name := range entries[start:end] {
+		if delimiter != "" && strings.HasSuffix(name, delimiter) {
+			prefixes += fmt.Sprintf("<BlobPrefix><Name>%s</Name></BlobPrefix>", name)
+			continue
+		}
+
+		blobs += fmt.Sprintf("<Blob><Name>%s</Name><Properties><Last-Modified>%s</Last-Modified><Etag>0x%d</Etag>"+
+			"<Content-Length>%d</Content-Length><Content-Type>text/plain</Content-Type><Content-MD5>AAAAAAAAAAAAAAAAAAAAAA==</Content-MD5>"+
+			"<BlobType>BlockBlob</BlobType></Properties><Metadata><job>nightly</job></Metadata></Blob>",
+			name, time.Unix(0, 0).UTC().Format(http.TimeFormat), len(name), len(name))
+	}
+
+	next := ""
+	if end < len(entries) {
+		next = strconv.Itoa(end)
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="results"><Blobs>%s%s</Blobs><NextMarker>%s</NextMarker></EnumerationResults>`, blobs, prefixes, next)
+}
+
+func TestListBlobs(t *testing.T) {
+	server := &listServer{names: []string{
+		"reports/2018-07/a.csv",
+		"reports/2018-07/b.csv",
+		"reports/2018-08-01.csv",
+		"reports/2018-08-02.csv",
+		"reports/readme.txt",
+		"other.txt",
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	c := newTestBlobClient(ts.URL)
+
+	tests := []struct {
+		name string
+		opts ListOptions
+		want []string
+	}{
+		{"flat", ListOptions{}, []string{"other.txt", "reports/2018-07/a.csv", "reports/2018-07/b.csv", "reports/2018-08-01.csv", "reports/2018-08-02.csv", "reports/readme.txt"}},
+		{"prefix", ListOptions{Prefix: "reports/2018-08"}, []string{"reports/2018-08-01.csv", "reports/2018-08-02.csv"}},
+		{"directory", ListOptions{Prefix: "reports/", Delimiter: "/"}, []string{"reports/2018-07/", "reports/2018-08-01.csv", "reports/2018-08-02.csv", "reports/readme.txt"}},
+		{"paged directory", ListOptions{Prefix: "reports/", Delimiter: "/", PageSize: 1}, []string{"reports/2018-07/", "reports/2018-08-01.csv", "reports/2018-08-02.csv", "reports/readme.txt"}},
+		{"root", ListOptions{Delimiter: "/", PageSize: 2}, []string{"other.txt", "reports/"}},
+		{"no match", ListOptions{Prefix: "missing/"}, nil},
+	}
+
+	for _, tt := range tests {
+		server.requests = nil
+
+		items, err := c.ListBlobs(context.Background(), "results", tt.opts)
+		if err != nil {
+			t.Errorf("%s: ListBlobs failed: %v", tt.name, err)
+			continue
+		}
+
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+			if item.Dir != strings.HasSuffix(item.Name, "/") {
+				t.Errorf("%s: %s has Dir %v", tt.name, item.Name, item.Dir)
+			}
+		}
+
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: listed %v, want %v", tt.name, names, tt.want)
+		}
+
+		pages := 1
+		if tt.opts.PageSize > 0 && len(tt.want) > 0 {
+			pages = (len(tt.want) + int(tt.opts.PageSize) - 1) / int(tt.opts.PageSize)
+		}
+		if len(server.requests) != pages {
+			t.Errorf("%s: sent %d requests, want one per page (%d): %v", tt.name, len(server.requests), pages, server.requests)
+		}
+	}
+}
+
+func TestListBlobsProperties(t *testing.T) {
+	ts := httptest.NewServer(&listServer{names: []string{"logs/app.log"}})
+	defer ts.Close()
+
+	items, err := newTestBlobClient(ts.URL).ListBlobs(context.Background(), "results", ListOptions{})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("ListBlobs = %+v, %v", items, err)
+	}
+
+	item := items[0]
+	if item.Size != 12 || item.ETag != "0x12" || item.ContentType != "text/plain" || len(item.ContentMD5) != 16 {
+		t.Errorf("properties = %+v", item)
+	}
+
+	if !item.LastModified.Equal(time.Unix(0, 0)) || item.Metadata["job"] != "nightly" {
+		t.Errorf("last modified %s, metadata %v", item.LastModified, item.Metadata)
+	}
+}
@@ -0,0 +1,67 @@
+package azstorage
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// newPipeline builds the same pipeline as azblob.NewPipeline with the shared
+// retry policy in place of azblob's own
+func newPipeline(cred pipeline.Factory, policy azretry.Policy) pipeline.Pipeline {
+	// Closest to the API goes first, the credential stays close to the wire so every try is signed
+	f := []pipeline.Factory{
+		azblob.NewTelemetryPolicyFactory(azblob.TelemetryOptions{}),
+		azblob.NewUniqueRequestIDPolicyFactory(),
+		newRetryPolicyFactory(policy),
+		cred,
+		pipeline.MethodFactoryMarker(),
+		azblob.NewRequestLogPolicyFactory(azblob.RequestLogOptions{}),
+	}
+
+	return pipeline.NewPipeline(f, pipeline.Options{})
+}
+
+// newRetryPolicyFactory retries blob requests with the shared retry policy
+func newRetryPolicyFactory(policy azretry.Policy) pipeline.Factory {
+	return pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			start := time.Now()
+
+			for attempt := 0; ; attempt++ {
+				// Every try starts from the original request and the start of the body
+				requestCopy := request.Copy()
+				if err := requestCopy.RewindBody(); err != nil {
+					return nil, err
+				}
+
+				response, err := next.Do(ctx, requestCopy)
+				if err == nil {
+					return response, nil
+				}
+
+				var resp *http.Response
+				if response != nil {
+					resp = response.Response()
+				}
+
+				delay, ok := policy.Next(start, attempt, resp, err)
+				if !ok {
+					return response, err
+				}
+
+				log.Printf("Retrying %s in %s, %s", azretry.Describe(request.Request), delay, azretry.Classify(resp, err))
+				azretry.Drain(resp)
+				if !azretry.Sleep(ctx, delay) {
+					// The response's body was drained and closed, don't hand it back
+					return nil, ctx.Err()
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package azstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+)
+
+// DefaultSASMaxExpiry is the longest a generated SAS may be valid for when the
+// client doesn't set its own maximum
+const DefaultSASMaxExpiry = time.Hour * 24 * 7
+
+// SASMaxExpiryVar holds the longest a SAS generated by the example may be valid for
+const SASMaxExpiryVar = "AZURE_STORAGE_SAS_MAX_EXPIRY"
+
+// SASOptions describes the access a generated SAS grants
+type SASOptions struct {
+	// Permissions in the service's notation: r(ead), a(dd), c(reate), w(rite),
+	// d(elete) and, for containers only, l(ist)
+	Permissions string
+	// Start is when the SAS becomes valid, right away when zero
+	Start time.Time
+	// Expiry is when the SAS stops being valid, it's required
+	Expiry time.Time
+	// IPRange limits the callers to an IPv4 address or a range like
+	// 10.0.0.1-10.0.0.255, anyone when empty
+	IPRange string
+	// HTTPSOnly refuses requests over plain HTTP
+	HTTPSOnly bool
+}
+
+// BlobSASURL returns a URL that grants access to a single blob until the
+// SAS expires, e.g. a short lived download link
+func (c *Client) BlobSASURL(ctx context.Context, containerName, blobName string, opts SASOptions) (string, error) {
+	if blobName == "" {
+		return "", fmt.Errorf("a blob SAS needs a blob name")
+	}
+
+	return c.sasURL(ctx, containerName, blobName, opts)
+}
+
+// ContainerSASURL returns a URL that grants access to every blob of a
+// container until the SAS expires
+func (c *Client) ContainerSASURL(ctx context.Context, containerName string, opts SASOptions) (string, error) {
+	return c.sasURL(ctx, containerName, "", opts)
+}
+
+// sasURL signs a service SAS with the account key. The key comes from the
+// connection string or is listed through resource manager, a client that
+// only has a SAS or a token can't sign one.
+func (c *Client) sasURL(ctx context.Context, containerName, blobName string, opts SASOptions) (string, error) {
+	if containerName == "" {
+		return "", fmt.Errorf("a SAS needs a container name")
+	}
+
+	values, err := c.sasSignatureValues(containerName, blobName, opts)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := c.signingKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(c.Endpoint())
+	if err != nil {
+		return "", fmt.Errorf("invalid blob endpoint '%s': %v", c.Endpoint(), err)
+	}
+
+	params := values.NewSASQueryParameters(azblob.NewSharedKeyCredential(c.StorageAccountName, key))
+
+	// The URL is only built, never sent
+	p := newPipeline(azblob.NewAnonymousCredential(), c.retryPolicy())
+	container := azblob.NewServiceURL(*u, p).NewContainerURL(containerName)
+	if blobName == "" {
+		sasURL := container.URL()
+		sasURL.RawQuery = params.Encode()
+		return sasURL.String(), nil
+	}
+
+	sasURL := container.NewBlobURL(blobName).URL()
+	sasURL.RawQuery = params.Encode()
+	return sasURL.String(), nil
+}
+
+// sasSignatureValues validates the options before they're signed, azblob
+// panics on invalid permissions
+func (c *Client) sasSignatureValues(containerName, blobName string, opts SASOptions) (azblob.BlobSASSignatureValues, error) {
+	values := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPSandHTTP,
+		StartTime:     opts.Start.UTC(),
+		ExpiryTime:    opts.Expiry.UTC(),
+		ContainerName: containerName,
+		BlobName:      blobName,
+	}
+
+	if opts.Permissions == "" {
+		return values, fmt.Errorf("a SAS needs permissions")
+	}
+
+	if blobName == "" {
+		perms := azblob.ContainerSASPermissions{}
+		if err := perms.Parse(opts.Permissions); err != nil {
+			return values, fmt.Errorf("invalid container SAS permissions '%s', use any of racwdl", opts.Permissions)
+		}
+		values.Permissions = perms.String()
+	} else {
+		perms := azblob.BlobSASPermissions{}
+		if err := perms.Parse(opts.Permissions); err != nil {
+			return values, fmt.Errorf("invalid blob SAS permissions '%s', use any of racwd", opts.Permissions)
+		}
+		values.Permissions = perms.String()
+	}
+
+	now := time.Now()
+	maxExpiry := c.SASMaxExpiry
+	if maxExpiry <= 0 {
+		maxExpiry = DefaultSASMaxExpiry
+	}
+
+	switch {
+	case opts.Expiry.IsZero():
+		return values, fmt.Errorf("a SAS needs an expiry")
+	case !opts.Expiry.After(now):
+		return values, fmt.Errorf("the expiry %s has passed", opts.Expiry.UTC().Format(time.RFC3339))
+	case opts.Expiry.Sub(now) > maxExpiry:
+		return values, fmt.Errorf("the expiry %s is more than %s away", opts.Expiry.UTC().Format(time.RFC3339), maxExpiry)
+	case !opts.Start.IsZero() && !opts.Start.Before(opts.Expiry):
+		return values, fmt.Errorf("the start %s isn't before the expiry", opts.Start.UTC().Format(time.RFC3339))
+	}
+
+	if opts.IPRange != "" {
+		ipRange, err := parseIPRange(opts.IPRange)
+		if err != nil {
+			return values, err
+		}
+		values.IPRange = ipRange
+	}
+
+	if opts.HTTPSOnly {
+		if strings.HasPrefix(c.Endpoint(), "http://") {
+			return values, fmt.Errorf("the blob endpoint %s is plain HTTP, an HTTPS only SAS couldn't be used with it", c.Endpoint())
+		}
+		values.Protocol = azblob.SASProtocolHTTPS
+	}
+
+	return values, nil
+}
+
+// signingKey returns the account key a SAS is signed with, the same key that
+// signs requests in shared key mode. Regenerating it revokes the SAS.
+func (c *Client) signingKey(ctx context.Context) (string, error) {
+	switch {
+	case c.AuthMode == AuthSAS:
+		return "", fmt.Errorf("a SAS can only be signed with the account key, not with another SAS")
+	case c.AccountKey != "":
+		if _, err := base64.StdEncoding.DecodeString(c.AccountKey); err != nil {
+			return "", fmt.Errorf("the key for storage account '%s' isn't valid base64", c.StorageAccountName)
+		}
+		return c.AccountKey, nil
+	case c.SubscriptionID != "":
+		key, err := c.accountKeys().current(ctx)
+		if err != nil {
+			return "", err
+		}
+		return key.value, nil
+	}
+
+	return "", fmt.Errorf("a SAS can only be signed with the account key, use a connection string with an AccountKey or the account's resource manager coordinates")
+}
+
+// parseIPRange reads an IPv4 address or a range of them, the only kind a SAS supports
+func parseIPRange(value string) (azblob.IPRange, error) {
+	parts := strings.SplitN(value, "-", 2)
+
+	var ips []net.IP
+	for _, part := range parts {
+		ip := net.ParseIP(strings.TrimSpace(part)).To4()
+		if ip == nil {
+			return azblob.IPRange{}, fmt.Errorf("invalid IP range '%s', use an IPv4 address or a range like 10.0.0.1-10.0.0.255", value)
+		}
+		ips = append(ips, ip)
+	}
+
+	ipRange := azblob.IPRange{Start: ips[0]}
+	if len(ips) == 2 {
+		if bytes.Compare(ips[0], ips[1]) > 0 {
+			return azblob.IPRange{}, fmt.Errorf("invalid IP range '%s', the start is after the end", value)
+		}
+		ipRange.End = ips[1]
+	}
+
+	return ipRange, nil
+}
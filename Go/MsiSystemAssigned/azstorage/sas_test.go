@@ -0,0 +1,156 @@
+package azstorage
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		start   string
+		end     string
+		wantErr bool
+	}{
+		{value: "10.0.0.1", start: "10.0.0.1"},
+		{value: "10.0.0.1-10.0.0.255", start: "10.0.0.1", end: "10.0.0.255"},
+		{value: " 10.0.0.1 - 10.0.0.255 ", start: "10.0.0.1", end: "10.0.0.255"},
+		{value: "10.0.0.5-10.0.0.5", start: "10.0.0.5", end: "10.0.0.5"},
+		{value: "10.0.0.255-10.0.0.1", wantErr: true},
+		{value: "10.0.0.0/24", wantErr: true},
+		{value: "::1", wantErr: true},
+		{value: "10.0.0.1-", wantErr: true},
+		{value: "example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseIPRange(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseIPRange(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseIPRange(%q) failed: %v", tt.value, err)
+			continue
+		}
+
+		if !got.Start.Equal(net.ParseIP(tt.start)) {
+			t.Errorf("parseIPRange(%q) start = %v, want %s", tt.value, got.Start, tt.start)
+		}
+
+		if (tt.end == "" && got.End != nil) || (tt.end != "" && !got.End.Equal(net.ParseIP(tt.end))) {
+			t.Errorf("parseIPRange(%q) end = %v, want %s", tt.value, got.End, tt.end)
+		}
+	}
+}
+
+func TestSASSignatureValues(t *testing.T) {
+	now := time.Now()
+	hour := now.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		endpoint  string
+		blobName  string
+		opts      SASOptions
+		wantPerms string
+		wantErr   bool
+	}{
+		{name: "blob read", blobName: "a.txt", opts: SASOptions{Permissions: "r", Expiry: hour}, wantPerms: "r"},
+		{name: "blob reordered", blobName: "a.txt", opts: SASOptions{Permissions: "wr", Expiry: hour}, wantPerms: "rw"},
+		{name: "container list", opts: SASOptions{Permissions: "rl", Expiry: hour}, wantPerms: "rl"},
+		{name: "blob list", blobName: "a.txt", opts: SASOptions{Permissions: "rl", Expiry: hour}, wantErr: true},
+		{name: "unknown permission", opts: SASOptions{Permissions: "rx", Expiry: hour}, wantErr: true},
+		{name: "no permissions", blobName: "a.txt", opts: SASOptions{Expiry: hour}, wantErr: true},
+		{name: "no expiry", blobName: "a.txt", opts: SASOptions{Permissions: "r"}, wantErr: true},
+		{name: "expired", blobName: "a.txt", opts: SASOptions{Permissions: "r", Expiry: now.Add(-time.Minute)}, wantErr: true},
+		{name: "too long", blobName: "a.txt", opts: SASOptions{Permissions: "r", Expiry: now.Add(DefaultSASMaxExpiry + time.Hour)}, wantErr: true},
+		{name: "start after expiry", blobName: "a.txt", opts: SASOptions{Permissions: "r", Start: now.Add(time.Hour * 2), Expiry: hour}, wantErr: true},
+		{name: "bad ip range", blobName: "a.txt", opts: SASOptions{Permissions: "r", Expiry: hour, IPRange: "10.0.0.0/8"}, wantErr: true},
+		{name: "https only", blobName: "a.txt", opts: SASOptions{Permissions: "r", Expiry: hour, HTTPSOnly: true}, wantPerms: "r"},
+		{name: "https only over http", endpoint: "http://127.0.0.1:10000/devstoreaccount1", blobName: "a.txt", opts: SASOptions{Permissions: "r", Expiry: hour, HTTPSOnly: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		c := &Client{StorageAccountName: "myaccount", BlobEndpoint: tt.endpoint}
+		values, err := c.sasSignatureValues("configs", tt.blobName, tt.opts)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: sasSignatureValues should fail", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: sasSignatureValues failed: %v", tt.name, err)
+			continue
+		}
+
+		if values.Permissions != tt.wantPerms {
+			t.Errorf("%s: permissions = %q, want %q", tt.name, values.Permissions, tt.wantPerms)
+		}
+
+		wantProtocol := azblob.SASProtocolHTTPSandHTTP
+		if tt.opts.HTTPSOnly {
+			wantProtocol = azblob.SASProtocolHTTPS
+		}
+		if values.Protocol != wantProtocol {
+			t.Errorf("%s: protocol = %q, want %q", tt.name, values.Protocol, wantProtocol)
+		}
+	}
+}
+
+func TestSASSignatureValuesMaxExpiry(t *testing.T) {
+	c := &Client{StorageAccountName: "myaccount", SASMaxExpiry: time.Hour}
+	opts := SASOptions{Permissions: "r", Expiry: time.Now().Add(time.Hour * 2)}
+
+	if _, err := c.sasSignatureValues("configs", "a.txt", opts); err == nil {
+		t.Error("an expiry past the client's SASMaxExpiry should be refused")
+	}
+}
+
+func TestBlobSASURL(t *testing.T) {
+	c := &Client{StorageAccountName: "myaccount", AccountKey: testAccountKey, Environment: azure.PublicCloud}
+
+	sasURL, err := c.BlobSASURL(context.Background(), "configs", "dir/a.txt", SASOptions{Permissions: "r", Expiry: time.Now().Add(time.Hour), IPRange: "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("BlobSASURL failed: %v", err)
+	}
+
+	u, err := url.Parse(sasURL)
+	if err != nil {
+		t.Fatalf("invalid SAS URL %q: %v", sasURL, err)
+	}
+
+	if u.Host != "myaccount.blob.core.windows.net" || u.Path != "/configs/dir/a.txt" {
+		t.Errorf("SAS URL = %s", sasURL)
+	}
+
+	q := u.Query()
+	if q.Get("sp") != "r" || q.Get("sr") != "b" || q.Get("sip") != "10.0.0.1" || q.Get("sig") == "" {
+		t.Errorf("SAS query = %v", q)
+	}
+}
+
+func TestSASNeedsAccountKey(t *testing.T) {
+	opts := SASOptions{Permissions: "r", Expiry: time.Now().Add(time.Hour)}
+
+	for _, c := range []*Client{
+		{StorageAccountName: "myaccount", AuthMode: AuthSAS, SASToken: "sig=abc"},
+		{StorageAccountName: "myaccount"},
+		{StorageAccountName: "myaccount", AccountKey: "not base64!"},
+	} {
+		if _, err := c.ContainerSASURL(context.Background(), "configs", opts); err == nil {
+			t.Errorf("a client with auth mode %q and no usable key should not sign a SAS", c.AuthMode)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package azstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+)
+
+// DefaultSyncConcurrency is the number of files transferred at once by a sync
+const DefaultSyncConcurrency = 4
+
+// SyncDir is the directory a sync keeps to itself below the synced
+// directory, holding the ETags of the blobs a sync down wrote and the
+// downloads it hasn't finished. It's never uploaded or deleted by a sync, and
+// blobs below a prefix's SyncDir are refused, so no blob name can overwrite
+// the sync's own files.
+const SyncDir = ".azsync"
+
+// syncStateFile is the name of the state below SyncDir
+const syncStateFile = "state.json"
+
+// The operations a sync plans
+const (
+	SyncUpload   = "upload"
+	SyncDownload = "download"
+	SyncDelete   = "delete"
+)
+
+// SyncOptions controls a sync between a directory and a container prefix
+type SyncOptions struct {
+	// Concurrency is the number of files transferred at once, DefaultSyncConcurrency when zero
+	Concurrency int
+	// Delete removes the files or blobs on the destination that aren't on the source
+	Delete bool
+	// DryRun only plans the sync, nothing is transferred or deleted
+	DryRun bool
+}
+
+// SyncAction is a file the sync transfers or deletes. Name is the path below
+// the directory and the prefix, with forward slashes.
+type SyncAction struct {
+	Op     string `json:"op"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+	Err    error  `json:"-"`
+}
+
+// SyncResult holds the actions of a sync and how many files were already in sync
+type SyncResult struct {
+	Actions   []SyncAction
+	Unchanged int
+}
+
+// Failed returns the actions that failed
+func (r *SyncResult) Failed() []SyncAction {
+	var failed []SyncAction
+	for _, action := range r.Actions {
+		if action.Err != nil {
+			failed = append(failed, action)
+		}
+	}
+
+	return failed
+}
+
+// SyncUp mirrors a local directory to the blobs below prefix, uploading the
+// files that are new or whose size or Content-MD5 differs. Failures are
+// reported per file in the result, the error is only set when the sync
+// couldn't be planned.
+func (c *Client) SyncUp(ctx context.Context, dir, containerName, prefix string, opts SyncOptions) (*SyncResult, error) {
+	prefix = syncPrefix(prefix)
+
+	files, err := localFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := c.syncBlobs(ctx, containerName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{}
+	for _, name := range sortedNames(files) {
+		size := files[name]
+		blob, ok := blobs[name]
+
+		reason := ""
+		switch {
+		case !ok:
+			reason = "new"
+		case blob.Size != size:
+			reason = "size changed"
+		case len(blob.ContentMD5) == 0:
+			reason = "blob has no Content-MD5"
+		default:
+			sum, err := fileMD5(filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(sum, blob.ContentMD5) {
+				reason = "content changed"
+			}
+		}
+
+		if reason == "" {
+			result.Unchanged++
+			continue
+		}
+
+		result.Actions = append(result.Actions, SyncAction{Op: SyncUpload, Name: name, Size: size, Reason: reason})
+	}
+
+	if opts.Delete {
+		for _, name := range sortedNames(blobSizes(blobs)) {
+			// A sync up never writes below SyncDir, so it doesn't delete there either
+			if _, ok := files[name]; !ok && !isSyncDir(name) {
+				result.Actions = append(result.Actions, SyncAction{Op: SyncDelete, Name: name, Size: blobs[name].Size, Reason: "not in " + dir})
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	run(result.Actions, opts.Concurrency, func(action *SyncAction) error {
+		if action.Op == SyncDelete {
+			return c.deleteBlob(ctx, containerName, prefix+action.Name)
+		}
+
+		_, err := c.UploadFile(ctx, containerName, prefix+action.Name, filepath.Join(dir, filepath.FromSlash(action.Name)), UploadOptions{})
+		return err
+	})
+
+	return result, nil
+}
+
+// SyncDown mirrors the blobs below prefix to a local directory, downloading
+// the blobs that are new or whose size changed, or whose ETag changed since
+// the last sync unless their Content-MD5 still matches the file. Failures are
+// reported per file in the result, the error is only set when the sync
+// couldn't be planned.
+func (c *Client) SyncDown(ctx context.Context, containerName, prefix, dir string, opts SyncOptions) (*SyncResult, error) {
+	prefix = syncPrefix(prefix)
+
+	blobs, err := c.syncBlobs(ctx, containerName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := localFiles(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	statePath := filepath.Join(dir, SyncDir, syncStateFile)
+	state, err := loadSyncState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{}
+	for _, name := range sortedNames(blobSizes(blobs)) {
+		blob := blobs[name]
+		path, err := localPath(dir, name)
+		if err != nil {
+			result.Actions = append(result.Actions, SyncAction{Op: SyncDownload, Name: name, Size: blob.Size, Reason: "unsafe name", Err: err})
+			continue
+		}
+
+		size, ok := files[name]
+
+		reason := ""
+		switch {
+		case !ok:
+			reason = "new"
+		case size != blob.Size:
+			reason = "size changed"
+		case state[name] == blob.ETag:
+		case len(blob.ContentMD5) == 0:
+			reason = "etag changed"
+		default:
+			sum, err := fileMD5(path)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(sum, blob.ContentMD5) {
+				reason = "content changed"
+			}
+		}
+
+		if reason == "" {
+			state[name] = blob.ETag
+			result.Unchanged++
+			continue
+		}
+
+		result.Actions = append(result.Actions, SyncAction{Op: SyncDownload, Name: name, Size: blob.Size, Reason: reason})
+	}
+
+	if opts.Delete {
+		for _, name := range sortedNames(files) {
+			if _, ok := blobs[name]; !ok {
+				result.Actions = append(result.Actions, SyncAction{Op: SyncDelete, Name: name, Size: files[name], Reason: "not in " + containerName + "/" + prefix})
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	var mu sync.Mutex
+	run(result.Actions, opts.Concurrency, func(action *SyncAction) error {
+		if action.Err != nil {
+			return action.Err
+		}
+
+		path, err := localPath(dir, action.Name)
+		if err != nil {
+			return err
+		}
+
+		if action.Op == SyncDelete {
+			mu.Lock()
+			delete(state, action.Name)
+			mu.Unlock()
+			return os.Remove(path)
+		}
+
+		// Unfinished downloads stay out of the way of the blobs' own names
+		opts := DownloadOptions{PartialPath: filepath.Join(dir, SyncDir, "partial", filepath.FromSlash(action.Name))}
+		if _, err := c.DownloadBlobToFile(ctx, containerName, prefix+action.Name, path, opts); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		state[action.Name] = blobs[action.Name].ETag
+		mu.Unlock()
+		return nil
+	})
+
+	for name := range state {
+		if _, ok := blobs[name]; !ok {
+			delete(state, name)
+		}
+	}
+
+	if err := state.save(statePath); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) deleteBlob(ctx context.Context, containerName, blobName string) error {
+	b, err := c.getBlobURL(ctx, containerName, blobName)
+	if err != nil {
+		return err
+	}
+
+	// A blob with snapshots can only be deleted along with them
+	_, err = b.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return err
+}
+
+// syncBlobs lists the blobs below prefix by their name without the prefix.
+// Directory marker blobs, names ending in a slash, are left out.
+func (c *Client) syncBlobs(ctx context.Context, containerName, prefix string) (map[string]BlobItem, error) {
+	items, err := c.ListBlobs(ctx, containerName, ListOptions{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := map[string]BlobItem{}
+	for _, item := range items {
+		name := strings.TrimPrefix(item.Name, prefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		blobs[name] = item
+	}
+
+	return blobs, nil
+}
+
+// run performs the actions, at most concurrency at a time, recording each one's error
+func run(actions []SyncAction, concurrency int, do func(action *SyncAction) error) {
+	if concurrency <= 0 {
+		concurrency = DefaultSyncConcurrency
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range actions {
+		wg.Add(1)
+		go func(action *SyncAction) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			action.Err = do(action)
+		}(&actions[i])
+	}
+	wg.Wait()
+}
+
+// localFiles lists the regular files below dir by their slash separated path
+// relative to dir, leaving out the sync's own SyncDir
+func localFiles(dir string) (map[string]int64, error) {
+	files := map[string]int64{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if info.IsDir() && name == SyncDir {
+			return filepath.SkipDir
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		files[name] = info.Size()
+		return nil
+	})
+
+	return files, err
+}
+
+// localPath returns where a blob is written below dir, refusing names that
+// would end up outside of it, e.g. ones with ".." segments, or in SyncDir
+func localPath(dir, name string) (string, error) {
+	root := filepath.Clean(dir)
+	path := filepath.Join(root, filepath.FromSlash(name))
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("blob name '%s' points outside of %s", name, dir)
+	}
+
+	if isSyncDir(filepath.ToSlash(rel)) {
+		return "", fmt.Errorf("blob name '%s' is in %s, where the sync keeps its state", name, SyncDir)
+	}
+
+	return path, nil
+}
+
+// isSyncDir reports whether a slash separated name is SyncDir or below it
+func isSyncDir(name string) bool {
+	return name == SyncDir || strings.HasPrefix(name, SyncDir+"/")
+}
+
+// syncPrefix makes a non empty prefix end in a slash, so it names a virtual directory
+func syncPrefix(prefix string) string {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return prefix
+}
+
+func fileMD5(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+func blobSizes(blobs map[string]BlobItem) map[string]int64 {
+	sizes := make(map[string]int64, len(blobs))
+	for name, blob := range blobs {
+		sizes[name] = blob.Size
+	}
+
+	return sizes
+}
+
+func sortedNames(files map[string]int64) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// syncState maps the names a sync down wrote to the ETag they were downloaded at
+type syncState map[string]string
+
+func loadSyncState(path string) (syncState, error) {
+	state := syncState{}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// A damaged state only means comparing by Content-MD5 again
+	if err := json.Unmarshal(data, &state); err != nil {
+		return syncState{}, nil
+	}
+
+	return state, nil
+}
+
+// save writes the state to a temp file and renames it into place
+func (s syncState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,292 @@
+package azstorage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		dir     string
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{dir: ".", name: "a.txt", want: "a.txt"},
+		{dir: ".", name: "sub/a.txt", want: filepath.Join("sub", "a.txt")},
+		{dir: "/", name: "etc/app.conf", want: filepath.Join("/", "etc", "app.conf")},
+		{dir: "/data", name: "a.txt", want: filepath.Join("/data", "a.txt")},
+		{dir: "/data/", name: "sub/a.txt", want: filepath.Join("/data", "sub", "a.txt")},
+		{dir: "data", name: "/a.txt", want: filepath.Join("data", "a.txt")},
+		{dir: "data", name: "..a.txt", want: filepath.Join("data", "..a.txt")},
+		{dir: "data", name: "sub/../a.txt", want: filepath.Join("data", "a.txt")},
+		{dir: ".", name: "../a.txt", wantErr: true},
+		{dir: "/data", name: "../etc/passwd", wantErr: true},
+		{dir: "/data", name: "sub/../../etc/passwd", wantErr: true},
+		{dir: "/data", name: "..", wantErr: true},
+		{dir: "/data", name: "", wantErr: true},
+		{dir: "/data", name: "sub/..", wantErr: true},
+		{dir: "/data", name: ".azsync.json", want: filepath.Join("/data", ".azsync.json")},
+		{dir: "/data", name: "sub/.azsync/x", want: filepath.Join("/data", "sub", ".azsync", "x")},
+		{dir: "/data", name: ".azsync", wantErr: true},
+		{dir: "/data", name: ".azsync/state.json", wantErr: true},
+		{dir: "/data", name: "sub/../.azsync/partial/a.txt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := localPath(tt.dir, tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("localPath(%q, %q) = %q, want an error", tt.dir, tt.name, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("localPath(%q, %q) failed: %v", tt.dir, tt.name, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("localPath(%q, %q) = %q, want %q", tt.dir, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSyncPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"configs", "configs/"},
+		{"configs/", "configs/"},
+		{"/configs", "configs/"},
+		{"a/b", "a/b/"},
+	}
+
+	for _, tt := range tests {
+		if got := syncPrefix(tt.prefix); got != tt.want {
+			t.Errorf("syncPrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestLocalFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.txt":                     "a",
+		"sub/b.txt":                 "bb",
+		".azsync/state.json":        "{}",
+		".azsync/partial/big.bin":   "",
+		".azsync/partial/sub/e.txt": "",
+		".azsync.json":              "{}",
+		"big.bin.partial":           "",
+		"sub/deeper/c.txt":          "ccc",
+		"sub/deeper/.azsync/d.txt":  "d",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := localFiles(dir)
+	if err != nil {
+		t.Fatalf("localFiles failed: %v", err)
+	}
+
+	// Only the top level SyncDir is the sync's own, files named like its old state are synced
+	want := map[string]int64{"a.txt": 1, "sub/b.txt": 2, ".azsync.json": 2, "big.bin.partial": 0, "sub/deeper/c.txt": 3, "sub/deeper/.azsync/d.txt": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("localFiles = %v, want %v", got, want)
+	}
+}
+
+func TestSyncState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, SyncDir, syncStateFile)
+
+	state, err := loadSyncState(path)
+	if err != nil || len(state) != 0 {
+		t.Fatalf("loadSyncState without a file = %v, %v, want an empty state", state, err)
+	}
+
+	state["a.txt"] = "0x1"
+	if err := state.save(path); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	loaded, err := loadSyncState(path)
+	if err != nil {
+		t.Fatalf("loadSyncState failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("loadSyncState = %v, want %v", loaded, state)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err = loadSyncState(path)
+	if err != nil || len(loaded) != 0 {
+		t.Errorf("a damaged state should load empty, got %v, %v", loaded, err)
+	}
+}
+
+// containerServer serves the blobs of one container to listings and downloads
+// and counts the downloads of every blob
+type containerServer struct {
+	blobs map[string]string
+
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (s *containerServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("comp") == "list" {
+		s.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/devstoreaccount1/data/")
+	data, ok := s.blobs[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("ETag", etagOf(data))
+	w.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	s.mu.Lock()
+	s.gets[name]++
+	s.mu.Unlock()
+
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end); err == nil {
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		data = data[start : end+1]
+	}
+	fmt.Fprint(w, data)
+}
+
+func (s *containerServer) list(w http.ResponseWriter, prefix string) {
+	var names []string
+	for name := range s.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var blobs string
+	for _, name := range names {
+		sum := md5.Sum([]byte(s.blobs[name]))
+		blobs += fmt.Sprintf("<Blob><Name>%s</Name><Properties><Last-Modified>%s</Last-Modified><Etag>%s</Etag>"+
+			"<Content-Length>%d</Content-Length><Content-MD5>%s</Content-MD5><BlobType>BlockBlob</BlobType></Properties></Blob>",
+			name, time.Unix(0, 0).UTC().Format(http.TimeFormat), etagOf(s.blobs[name]), len(s.blobs[name]), base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>%s</Blobs><NextMarker/></EnumerationResults>`, blobs)
+}
+
+func etagOf(data string) string {
+	return fmt.Sprintf(`"0x%x"`, md5.Sum([]byte(data)))
+}
+
+func TestSyncDownBlobNamesDontCollide(t *testing.T) {
+	server := &containerServer{
+		blobs: map[string]string{
+			"a.txt":                "a",
+			".azsync.json":         "not the sync's state",
+			"big.bin.partial":      "a blob that happens to end in .partial",
+			"big.bin.partial.json": "{}",
+			".azsync/state.json":   `{"a.txt": "forged"}`,
+		},
+		gets: map[string]int{},
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newTestBlobClient(ts.URL)
+
+	for run := 1; run <= 2; run++ {
+		result, err := c.SyncDown(context.Background(), "data", "", dir, SyncOptions{})
+		if err != nil {
+			t.Fatalf("run %d: SyncDown failed: %v", run, err)
+		}
+
+		failed := result.Failed()
+		if len(failed) != 1 || failed[0].Name != ".azsync/state.json" {
+			t.Errorf("run %d: failed %+v, want only the blob in the sync's own directory refused", run, failed)
+		}
+
+		if run == 2 && (len(result.Actions) != 1 || result.Unchanged != 4) {
+			t.Errorf("run 2: actions %+v, %d unchanged, want nothing downloaded again", result.Actions, result.Unchanged)
+		}
+	}
+
+	for name, data := range server.blobs {
+		if name == ".azsync/state.json" {
+			continue
+		}
+
+		if server.gets[name] != 1 {
+			t.Errorf("%s was downloaded %d times, want once", name, server.gets[name])
+		}
+
+		got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || string(got) != data {
+			t.Errorf("%s = %q, %v, want the blob's contents", name, got, err)
+		}
+	}
+
+	state, err := loadSyncState(filepath.Join(dir, SyncDir, syncStateFile))
+	if err != nil || state["a.txt"] != etagOf("a") || len(state) != 4 {
+		t.Errorf("the sync's state = %v, %v, want the ETags of the downloaded blobs", state, err)
+	}
+}
@@ -0,0 +1,254 @@
+package azstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+)
+
+// DefaultUploadConcurrency is the number of blocks uploaded at once
+const DefaultUploadConcurrency = 4
+
+// UploadOptions controls how a payload is written to a block blob
+type UploadOptions struct {
+	// BlockSize is the size of each staged block, DefaultBlockSize when zero.
+	// Payloads smaller than one block go in a single put. Each block in flight is
+	// held in memory.
+	BlockSize int64
+	// Concurrency is the number of blocks uploaded at once, DefaultUploadConcurrency when zero
+	Concurrency int
+	// ContentType of the blob, detected from the first block when empty
+	ContentType string
+	// Metadata stored with the blob
+	Metadata map[string]string
+	// NoOverwrite fails the upload with a 409 when the blob already exists
+	NoOverwrite bool
+	// Progress is called after every block with the bytes uploaded so far
+	Progress func(uploaded int64)
+}
+
+func (o UploadOptions) withDefaults() (UploadOptions, error) {
+	if o.BlockSize <= 0 {
+		o.BlockSize = DefaultBlockSize
+	}
+
+	if o.BlockSize > azblob.BlockBlobMaxPutBlockBytes {
+		return o, fmt.Errorf("the block size can't exceed %d bytes", azblob.BlockBlobMaxPutBlockBytes)
+	}
+
+	if o.Concurrency <= 0 {
+		o.Concurrency = DefaultUploadConcurrency
+	}
+
+	return o, nil
+}
+
+// UploadBlob writes everything read from r to a block blob and returns its
+// size. A payload smaller than one block is sent in a single put, larger ones
+// are staged as blocks in parallel and committed once all of them are in. The
+// blob's Content-MD5 is computed while reading, so readers that can't seek
+// work too.
+func (c *Client) UploadBlob(ctx context.Context, containerName, blobName string, r io.Reader, opts UploadOptions) (int64, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := c.getBlobURL(ctx, containerName, blobName)
+	if err != nil {
+		return 0, err
+	}
+	bb := b.ToBlockBlobURL()
+
+	sum := md5.New()
+	first, err := readBlock(r, opts.BlockSize, sum)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	if opts.ContentType == "" {
+		opts.ContentType = http.DetectContentType(first)
+	}
+
+	ac := azblob.BlobAccessConditions{}
+	if opts.NoOverwrite {
+		ac.HTTPAccessConditions.IfNoneMatch = azblob.ETagAny
+	}
+
+	if err == io.EOF {
+		h := azblob.BlobHTTPHeaders{ContentType: opts.ContentType}
+		copy(h.ContentMD5[:], sum.Sum(nil))
+
+		if _, err := bb.PutBlob(ctx, bytes.NewReader(first), h, opts.Metadata, ac); err != nil {
+			return 0, err
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(int64(len(first)))
+		}
+		return int64(len(first)), nil
+	}
+
+	ids, size, err := stageBlocks(ctx, bb, first, r, sum, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	h := azblob.BlobHTTPHeaders{ContentType: opts.ContentType}
+	copy(h.ContentMD5[:], sum.Sum(nil))
+
+	if _, err := bb.PutBlockList(ctx, ids, h, opts.Metadata, ac); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// UploadFile uploads a local file to a block blob, taking the content type
+// from the file's extension unless one is given
+func (c *Client) UploadFile(ctx context.Context, containerName, blobName, path string, opts UploadOptions) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if opts.ContentType == "" {
+		opts.ContentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+
+	return c.UploadBlob(ctx, containerName, blobName, f, opts)
+}
+
+// stageBlocks reads r block by block, starting with the block already read,
+// and uploads the blocks in parallel. It returns the block IDs in order and
+// the payload's size.
+func stageBlocks(ctx context.Context, bb azblob.BlockBlobURL, first []byte, r io.Reader, sum hash.Hash, opts UploadOptions) ([]string, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefix, err := blockIDPrefix()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sem := make(chan struct{}, opts.Concurrency)
+
+	var (
+		ids      []string
+		size     int64
+		uploaded int64
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	block := first
+	for {
+		if len(ids) == azblob.BlockBlobMaxBlocks {
+			fail(fmt.Errorf("the payload needs more than %d blocks, use a larger block size", azblob.BlockBlobMaxBlocks))
+			break
+		}
+
+		// Every ID of a blob has to be the same length
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%06d", prefix, len(ids))))
+		ids = append(ids, id)
+		size += int64(len(block))
+
+		// Waiting for a slot bounds the blocks held in memory
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(id string, block []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// The pipeline retries a failed block on its own
+			if _, err := bb.PutBlock(ctx, id, bytes.NewReader(block), azblob.LeaseAccessConditions{}); err != nil {
+				fail(err)
+				return
+			}
+
+			if opts.Progress != nil {
+				mu.Lock()
+				uploaded += int64(len(block))
+				opts.Progress(uploaded)
+				mu.Unlock()
+			}
+		}(id, block)
+
+		var err error
+		block, err = readBlock(r, opts.BlockSize, sum)
+		if err == io.EOF && len(block) == 0 {
+			break
+		} else if err != nil && err != io.EOF {
+			fail(err)
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, 0, firstErr
+	}
+
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+
+	return ids, size, nil
+}
+
+// readBlock reads up to size bytes into a new buffer and adds them to sum. It
+// returns io.EOF along with the last bytes once r is exhausted.
+func readBlock(r io.Reader, size int64, sum hash.Hash) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := io.ReadFull(r, buf)
+	sum.Write(buf[:n])
+
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+
+	return buf[:n], err
+}
+
+// blockIDPrefix keeps the uncommitted blocks of one upload apart from another's
+func blockIDPrefix() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
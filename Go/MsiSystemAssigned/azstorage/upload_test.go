@@ -0,0 +1,192 @@
+package azstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+)
+
+// blockServer keeps the blocks and blobs put to it, rejecting puts with
+// If-None-Match: * when the blob exists
+type blockServer struct {
+	mu     sync.Mutex
+	blocks map[string][]byte
+	blobs  map[string][]byte
+	puts   []*http.Request
+}
+
+func newBlockServer() *blockServer {
+	return &blockServer{blocks: map[string][]byte{}, blobs: map[string][]byte{}}
+}
+
+func (s *blockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Query().Get("comp") {
+	case "block":
+		s.blocks[r.URL.Query().Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+		return
+	case "blocklist":
+		var list azblob.BlockLookupList
+		if err := xml.Unmarshal(body, &list); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var data []byte
+		for _, id := range list.Latest {
+			block, ok := s.blocks[id]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data = append(data, block...)
+		}
+		body = data
+	}
+
+	if _, exists := s.blobs[r.URL.Path]; exists && r.Header.Get("If-None-Match") == "*" {
+		w.Header().Set("x-ms-error-code", "BlobAlreadyExists")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	s.blobs[r.URL.Path] = body
+	s.puts = append(s.puts, r)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func md5Base64(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestUploadBlobSinglePut(t *testing.T) {
+	server := newBlockServer()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	data := []byte("small payload")
+	c := newTestBlobClient(ts.URL)
+	opts := UploadOptions{BlockSize: 64, ContentType: "text/plain", Metadata: map[string]string{"job": "nightly"}}
+
+	size, err := c.UploadBlob(context.Background(), "results", "a.txt", bytes.NewReader(data), opts)
+	if err != nil || size != int64(len(data)) {
+		t.Fatalf("UploadBlob = %d, %v", size, err)
+	}
+
+	if len(server.blocks) != 0 || len(server.puts) != 1 {
+		t.Fatalf("staged %d blocks and put %d blobs, want a single put", len(server.blocks), len(server.puts))
+	}
+
+	put := server.puts[0]
+	if got := put.Header.Get("x-ms-blob-content-md5"); got != md5Base64(data) {
+		t.Errorf("Content-MD5 = %q, want %q", got, md5Base64(data))
+	}
+	if got := put.Header.Get("x-ms-blob-content-type"); got != "text/plain" {
+		t.Errorf("content type = %q", got)
+	}
+	if got := put.Header.Get("x-ms-meta-job"); got != "nightly" {
+		t.Errorf("metadata job = %q", got)
+	}
+	if got := put.Header.Get("If-None-Match"); got != "" {
+		t.Errorf("If-None-Match = %q without NoOverwrite", got)
+	}
+}
+
+func TestUploadBlobStagesBlocks(t *testing.T) {
+	server := newBlockServer()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// Ten blocks, the last one short
+	data := []byte(strings.Repeat("0123456789", 19))
+	c := newTestBlobClient(ts.URL)
+
+	var uploaded int64
+	opts := UploadOptions{BlockSize: 20, Concurrency: 3, Progress: func(n int64) { uploaded = n }}
+
+	size, err := c.UploadBlob(context.Background(), "results", "b.bin", bytes.NewReader(data), opts)
+	if err != nil || size != int64(len(data)) {
+		t.Fatalf("UploadBlob = %d, %v", size, err)
+	}
+
+	if len(server.blocks) != 10 || len(server.puts) != 1 {
+		t.Fatalf("staged %d blocks and committed %d lists, want 10 blocks and one commit", len(server.blocks), len(server.puts))
+	}
+
+	idLength := -1
+	for id := range server.blocks {
+		if idLength >= 0 && len(id) != idLength {
+			t.Errorf("block IDs of one blob must have the same length, got %d and %d", idLength, len(id))
+		}
+		idLength = len(id)
+	}
+
+	if got := server.blobs["/devstoreaccount1/results/b.bin"]; !bytes.Equal(got, data) {
+		t.Errorf("committed %q, want the blocks in order", got)
+	}
+
+	commit := server.puts[0]
+	if got := commit.Header.Get("x-ms-blob-content-md5"); got != md5Base64(data) {
+		t.Errorf("Content-MD5 = %q, want the whole payload's %q", got, md5Base64(data))
+	}
+	if got := commit.Header.Get("x-ms-blob-content-type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("content type = %q, want it detected from the first block", got)
+	}
+	if uploaded != int64(len(data)) {
+		t.Errorf("progress ended at %d, want %d", uploaded, len(data))
+	}
+}
+
+func TestUploadBlobNoOverwrite(t *testing.T) {
+	server := newBlockServer()
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	c := newTestBlobClient(ts.URL)
+	opts := UploadOptions{BlockSize: 4, NoOverwrite: true}
+
+	for _, payload := range []string{"abc", "longer than a block"} {
+		if _, err := c.UploadBlob(context.Background(), "results", "c.txt", strings.NewReader(payload), opts); err != nil {
+			t.Fatalf("the first upload failed: %v", err)
+		}
+
+		_, err := c.UploadBlob(context.Background(), "results", "c.txt", strings.NewReader("new"), opts)
+		if se, ok := err.(azblob.StorageError); !ok || se.Response().StatusCode != http.StatusConflict {
+			t.Errorf("overwriting with NoOverwrite = %v, want a 409", err)
+		}
+
+		delete(server.blobs, "/devstoreaccount1/results/c.txt")
+	}
+}
+
+func TestUploadOptionsBlockSize(t *testing.T) {
+	if _, err := (UploadOptions{BlockSize: azblob.BlockBlobMaxPutBlockBytes + 1}).withDefaults(); err == nil {
+		t.Error("a block size over the service's limit should be refused")
+	}
+
+	opts, err := UploadOptions{}.withDefaults()
+	if err != nil || opts.BlockSize != DefaultBlockSize || opts.Concurrency != DefaultUploadConcurrency {
+		t.Errorf("withDefaults = %+v, %v", opts, err)
+	}
+}
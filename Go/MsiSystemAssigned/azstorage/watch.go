@@ -0,0 +1,222 @@
+package azstorage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/2016-05-31/azblob"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// BlobConditions skip a download when the blob hasn't changed
+type BlobConditions struct {
+	// IfNoneMatch skips the download while the blob's ETag still matches
+	IfNoneMatch string
+	// IfModifiedSince skips the download unless the blob changed after it
+	IfModifiedSince time.Time
+}
+
+// BlobVersion identifies the version of a blob a download returned
+type BlobVersion struct {
+	ETag         string
+	LastModified time.Time
+	Size         int64
+}
+
+// OpenBlobIf streams a blob's contents unless the conditions say it hasn't
+// changed, in which case the reader and version are nil. The caller closes
+// the reader.
+func (c *Client) OpenBlobIf(ctx context.Context, containerName, blobName string, cond BlobConditions) (io.ReadCloser, *BlobVersion, error) {
+	b, err := c.getBlobURL(ctx, containerName, blobName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ac := azblob.BlobAccessConditions{HTTPAccessConditions: azblob.HTTPAccessConditions{
+		IfNoneMatch:     azblob.ETag(cond.IfNoneMatch),
+		IfModifiedSince: cond.IfModifiedSince,
+	}}
+
+	resp, err := b.GetBlob(ctx, azblob.BlobRange{}, ac, false)
+	if azretry.StatusCode(err) == http.StatusNotModified {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	version := &BlobVersion{
+		ETag:         string(resp.ETag()),
+		LastModified: resp.LastModified(),
+		Size:         resp.ContentLength(),
+	}
+
+	return resp.Body(), version, nil
+}
+
+// BlobWatcher polls a blob and writes it to a local file whenever its ETag
+// changes, notifying subscribers once the new content is in place
+type BlobWatcher struct {
+	client        *Client
+	containerName string
+	blobName      string
+	path          string
+	interval      time.Duration
+
+	mu        sync.RWMutex
+	latest    *BlobVersion
+	callbacks []func(BlobVersion)
+}
+
+// NewBlobWatcher creates a watcher that keeps path in sync with the blob. The
+// file is replaced atomically, readers see either the old or the new content.
+// The file belongs to the watcher, local edits that leave it newer than the
+// blob are only overwritten once the blob changes.
+func (c *Client) NewBlobWatcher(containerName, blobName, path string, interval time.Duration) *BlobWatcher {
+	return &BlobWatcher{
+		client:        c,
+		containerName: containerName,
+		blobName:      blobName,
+		path:          path,
+		interval:      interval,
+	}
+}
+
+// OnChange registers a callback invoked with the new version whenever the file was replaced
+func (w *BlobWatcher) OnChange(callback func(BlobVersion)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.callbacks = append(w.callbacks, callback)
+}
+
+// Latest returns the version the file holds, or nil before the first poll
+func (w *BlobWatcher) Latest() *BlobVersion {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.latest
+}
+
+// Poll downloads the blob if its ETag changed and invokes the callbacks. The
+// first poll of a file written before, e.g. by an earlier container, only
+// downloads the blob if it was modified since.
+func (w *BlobWatcher) Poll(ctx context.Context) (bool, error) {
+	latest := w.Latest()
+
+	cond := BlobConditions{}
+	if latest != nil {
+		cond.IfNoneMatch = latest.ETag
+	} else if info, err := os.Stat(w.path); err == nil {
+		// The file's modification time is set to the blob's when it's written
+		cond.IfModifiedSince = info.ModTime()
+	}
+
+	body, version, err := w.client.OpenBlobIf(ctx, w.containerName, w.blobName, cond)
+	if err != nil {
+		return false, err
+	}
+
+	if body == nil {
+		if latest != nil {
+			return false, nil
+		}
+
+		// The file is current, pick up its version without downloading it again
+		_, props, err := w.client.blobProperties(ctx, w.containerName, w.blobName)
+		if err != nil {
+			return false, err
+		}
+		version = &BlobVersion{ETag: string(props.ETag()), LastModified: props.LastModified(), Size: props.ContentLength()}
+	} else {
+		defer body.Close()
+		if err := writeFileAtomic(w.path, body, version.LastModified); err != nil {
+			return false, err
+		}
+	}
+
+	w.mu.Lock()
+	w.latest = version
+	callbacks := make([]func(BlobVersion), len(w.callbacks))
+	copy(callbacks, w.callbacks)
+	w.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(*version)
+	}
+
+	return true, nil
+}
+
+// Run polls the blob on the watcher's interval until the context is done.
+// Failed polls are logged and retried on the next tick, keeping the last
+// written file in place.
+func (w *BlobWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := w.Poll(ctx)
+			if err != nil {
+				log.Printf("Failed to poll blob '%s/%s': %v", w.containerName, w.blobName, err)
+				continue
+			}
+
+			if changed {
+				log.Printf("Blob '%s/%s' changed to ETag %s, wrote %s", w.containerName, w.blobName, w.Latest().ETag, w.path)
+			}
+		}
+	}
+}
+
+// writeFileAtomic writes r to a temp file next to path and renames it into
+// place, stamping it with the blob's modification time
+func writeFileAtomic(path string, r io.Reader, modTime time.Time) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// Removing the temp file after the rename fails harmlessly
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// TempFile creates the file readable by its owner only
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := os.Chtimes(f.Name(), modTime, modTime); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
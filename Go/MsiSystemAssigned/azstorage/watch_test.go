@@ -0,0 +1,253 @@
+package azstorage
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// changingBlobServer serves a blob that can be replaced between requests,
+// answering conditional downloads with 304 Not Modified while they hold
+type changingBlobServer struct {
+	mu       sync.Mutex
+	data     string
+	etag     string
+	modified time.Time
+	gets     int
+	headers  []http.Header
+}
+
+func (s *changingBlobServer) set(data, etag string, modified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data, s.etag, s.modified = data, etag, modified
+}
+
+func (s *changingBlobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("ETag", s.etag)
+	w.Header().Set("Last-Modified", s.modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+		return
+	}
+
+	s.gets++
+	s.headers = append(s.headers, r.Header)
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == s.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !s.modified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+	w.Write([]byte(s.data))
+}
+
+func TestOpenBlobIf(t *testing.T) {
+	modified := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	server := &changingBlobServer{data: "v1", etag: `"0x1"`, modified: modified}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	c := newTestBlobClient(ts.URL)
+
+	body, version, err := c.OpenBlobIf(context.Background(), "data", "a.txt", BlobConditions{})
+	if err != nil || body == nil {
+		t.Fatalf("OpenBlobIf without conditions = %v, %v, want the blob", body, err)
+	}
+	got, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil || string(got) != "v1" {
+		t.Errorf("the body = %q, %v, want v1", got, err)
+	}
+	if version.ETag != `"0x1"` || !version.LastModified.Equal(modified) || version.Size != 2 {
+		t.Errorf("the version = %+v, want the blob's ETag, modification time and size", version)
+	}
+
+	tests := []struct {
+		name string
+		cond BlobConditions
+	}{
+		{"matching ETag", BlobConditions{IfNoneMatch: `"0x1"`}},
+		{"not modified since", BlobConditions{IfModifiedSince: modified}},
+	}
+
+	for _, tt := range tests {
+		body, version, err := c.OpenBlobIf(context.Background(), "data", "a.txt", tt.cond)
+		if body != nil || version != nil || err != nil {
+			t.Errorf("%s: OpenBlobIf = %v, %+v, %v, want nil for an unchanged blob", tt.name, body, version, err)
+		}
+	}
+
+	// A 304 isn't retried
+	if server.gets != 3 {
+		t.Errorf("the blob was requested %d times, want 3", server.gets)
+	}
+}
+
+func TestBlobWatcherPoll(t *testing.T) {
+	modified := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	server := &changingBlobServer{data: "v1", etag: `"0x1"`, modified: modified}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "conf", "app.conf")
+	c := newTestBlobClient(ts.URL)
+
+	var changes []BlobVersion
+	watcher := c.NewBlobWatcher("data", "app.conf", path, time.Minute)
+	watcher.OnChange(func(version BlobVersion) {
+		changes = append(changes, version)
+	})
+
+	if watcher.Latest() != nil {
+		t.Errorf("Latest before the first poll = %+v, want nil", watcher.Latest())
+	}
+
+	checkFile := func(step, want string, modTime time.Time) {
+		got, err := ioutil.ReadFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: the file = %q, %v, want %q", step, got, err, want)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) || info.Mode().Perm() != 0644 {
+			t.Errorf("%s: the file's info = %v, %v, want mode 0644 modified at %s", step, info, err, modTime)
+		}
+	}
+
+	changed, err := watcher.Poll(context.Background())
+	if err != nil || !changed {
+		t.Fatalf("the first Poll = %v, %v, want the file written", changed, err)
+	}
+	checkFile("first poll", "v1", modified)
+	if len(changes) != 1 || changes[0].ETag != `"0x1"` {
+		t.Errorf("first poll: the callbacks saw %+v, want ETag 0x1", changes)
+	}
+
+	changed, err = watcher.Poll(context.Background())
+	if err != nil || changed {
+		t.Errorf("Poll of an unchanged blob = %v, %v, want false", changed, err)
+	}
+	if len(changes) != 1 {
+		t.Errorf("the callbacks ran for an unchanged blob: %+v", changes)
+	}
+	if got := server.headers[1].Get("If-None-Match"); got != `"0x1"` {
+		t.Errorf("the second poll sent If-None-Match %q, want the ETag of the first", got)
+	}
+
+	modified = modified.Add(time.Hour)
+	server.set("v2", `"0x2"`, modified)
+
+	changed, err = watcher.Poll(context.Background())
+	if err != nil || !changed {
+		t.Fatalf("Poll of a changed blob = %v, %v, want the file replaced", changed, err)
+	}
+	checkFile("changed blob", "v2", modified)
+	if len(changes) != 2 || changes[1].ETag != `"0x2"` || watcher.Latest().ETag != `"0x2"` {
+		t.Errorf("changed blob: the callbacks saw %+v and Latest is %+v, want ETag 0x2", changes, watcher.Latest())
+	}
+
+	// The temp files were renamed into place
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("the file's directory holds %d entries, %v, want only the file", len(entries), err)
+	}
+}
+
+func TestBlobWatcherExistingFile(t *testing.T) {
+	modified := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	server := &changingBlobServer{data: "v1", etag: `"0x1"`, modified: modified}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Written by an earlier container
+	path := filepath.Join(dir, "app.conf")
+	if err := ioutil.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestBlobClient(ts.URL)
+
+	var changes []BlobVersion
+	watcher := c.NewBlobWatcher("data", "app.conf", path, time.Minute)
+	watcher.OnChange(func(version BlobVersion) {
+		changes = append(changes, version)
+	})
+
+	changed, err := watcher.Poll(context.Background())
+	if err != nil || !changed {
+		t.Fatalf("the first Poll = %v, %v, want the version picked up", changed, err)
+	}
+
+	if server.headers[0].Get("If-Modified-Since") == "" {
+		t.Error("the first poll of an existing file should send If-Modified-Since")
+	}
+	if server.gets != 1 {
+		t.Errorf("the blob was requested %d times, want once", server.gets)
+	}
+	if latest := watcher.Latest(); latest == nil || latest.ETag != `"0x1"` || latest.Size != 2 {
+		t.Errorf("Latest = %+v, want the blob's version from its properties", latest)
+	}
+	if len(changes) != 1 {
+		t.Errorf("the callbacks saw %+v, want the current version once", changes)
+	}
+
+	changed, err = watcher.Poll(context.Background())
+	if err != nil || changed {
+		t.Errorf("the second Poll = %v, %v, want false", changed, err)
+	}
+	if got := server.headers[1].Get("If-None-Match"); got != `"0x1"` {
+		t.Errorf("the second poll sent If-None-Match %q, want the ETag from the properties", got)
+	}
+
+	// A file newer than the blob isn't overwritten until the blob changes
+	watcher = c.NewBlobWatcher("data", "app.conf", path, time.Minute)
+	edited := modified.Add(time.Minute)
+	if err := ioutil.WriteFile(path, []byte("local edit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, edited, edited); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll of a locally edited file failed: %v", err)
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || string(got) != "local edit" {
+		t.Errorf("the edited file = %q, %v, want it kept", got, err)
+	}
+}
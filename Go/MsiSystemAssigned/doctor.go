@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/samkreter/container-instance-examples/Go/MsiSystemAssigned/azstorage"
+	"github.com/samkreter/container-instance-examples/Go/shared/azdoctor"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// runDoctor checks the environment, identity, network and role assignments
+// reading the blob relies on and prints what to fix. In shared key mode the
+// account keys have to be listable as well.
+func runDoctor() error {
+	ctx := context.Background()
+	report := &azdoctor.Report{}
+
+	envCheck := azdoctor.Env(requiredEnv()...)
+	report.Add(envCheck)
+
+	res, chain := azdoctor.Credential("")
+	report.Add(res)
+
+	if chain == nil || envCheck.Status != azdoctor.Pass {
+		return writeReport(report)
+	}
+
+	report.Add(azdoctor.IMDS(ctx, chain))
+
+	if secretRef, ok := os.LookupEnv("AZURE_STORAGE_SECRET"); ok {
+		results, claims := azdoctor.Tokens(ctx, chain, "keyvault")
+		report.Add(results...)
+
+		vaultName := os.Getenv("KEYVAULT_VAULT_NAME")
+		// Failures are reported as they happen instead of being ridden out
+		keyClient := azkeyvault.NewKeyVaultClientWithPolicy(chain.Environment, vaultName, chain, azretry.NoRetries)
+
+		secretCheck := azdoctor.SecretAccess(ctx, keyClient, vaultName, secretRef, claims["keyvault"])
+		report.Add(secretCheck)
+		if secretCheck.Status != azdoctor.Pass {
+			return writeReport(report)
+		}
+	}
+
+	azStorage, err := newStorageClientWithPolicy(chain.Environment, azretry.NoRetries)
+	if err != nil {
+		report.Add(azdoctor.Result{Check: "storage client", Status: azdoctor.Fail, Detail: err.Error(), Hint: "fix the connection string or SAS URL"})
+		return writeReport(report)
+	}
+
+	report.Add(azdoctor.DNS(ctx, azStorage.Endpoint()))
+
+	container := defaultContainer(azStorage)
+
+	blobCheck := "blob " + container + "/" + blobName
+	blobHints := azdoctor.Hints{
+		http.StatusNotFound: fmt.Sprintf("upload %s to the %s container of account %s", blobName, container, azStorage.StorageAccountName),
+	}
+
+	// Clients from a connection string or SAS don't know the account's resource ID
+	scope := "<storage account resource id>"
+	if azStorage.SubscriptionID != "" {
+		azStorage.Credential = chain
+		scope = fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s", azStorage.SubscriptionID, azStorage.ResourceGroupName, azStorage.StorageAccountName)
+	}
+
+	switch {
+	case azStorage.AuthMode == azstorage.AuthSAS:
+		blobHints[http.StatusForbidden] = "the SAS was rejected, check it hasn't expired and grants read permission"
+	case azStorage.AuthMode == azstorage.AuthSharedKey && azStorage.AccountKey != "":
+		blobHints[http.StatusForbidden] = "the account key was rejected, check the connection string has the current key"
+	case azStorage.AuthMode == azstorage.AuthSharedKey:
+		// The account key is listed through resource manager
+		results, claims := azdoctor.Tokens(ctx, chain, "management")
+		report.Add(results...)
+		report.Add(azdoctor.DNS(ctx, chain.Environment.ResourceManagerEndpoint))
+
+		principal := azdoctor.Principal(claims["management"])
+		keyCheck := azdoctor.Probe("list keys "+azStorage.StorageAccountName, azStorage.CheckKeyAccess(ctx), "", azdoctor.Hints{
+			http.StatusForbidden: fmt.Sprintf("grant principal %s a role that can list the account keys: az role assignment create --assignee-object-id %s --role \"Storage Account Key Operator Service Role\" --scope %s", principal, principal, scope),
+			http.StatusNotFound:  fmt.Sprintf("storage account %s wasn't found in resource group %s of subscription %s, check ACCOUNT_NAME, RESOURCE_GROUP and SUBID", azStorage.StorageAccountName, azStorage.ResourceGroupName, azStorage.SubscriptionID),
+		})
+		report.Add(keyCheck)
+
+		if keyCheck.Status != azdoctor.Pass {
+			report.Add(azdoctor.Result{Check: blobCheck, Status: azdoctor.Skip, Detail: "needs the account key"})
+			return writeReport(report)
+		}
+
+		blobHints[http.StatusForbidden] = "the account rejected the request, check its firewall and virtual network rules allow the container group"
+	default:
+		results, claims := azdoctor.Tokens(ctx, chain, "storage")
+		report.Add(results...)
+
+		principal := azdoctor.Principal(claims["storage"])
+		blobHints[http.StatusForbidden] = fmt.Sprintf("grant principal %s read access to blobs: az role assignment create --assignee-object-id %s --role \"Storage Blob Data Reader\" --scope %s, or check the account's firewall allows the container group", principal, principal, scope)
+	}
+
+	report.Add(azdoctor.Probe(blobCheck, azStorage.CheckBlobAccess(ctx, container, blobName), "", blobHints))
+	return writeReport(report)
+}
+
+// requiredEnv lists the variables the storage client needs, none when it's
+// configured with a connection string or SAS
+func requiredEnv() []string {
+	for _, name := range []string{"AZURE_STORAGE_CONNECTION_STRING", "AZURE_STORAGE_SAS_URL", "AZURE_STORAGE_SECRET"} {
+		if _, ok := os.LookupEnv(name); ok {
+			return nil
+		}
+	}
+
+	return []string{"SUBID", "RESOURCE_GROUP", "ACCOUNT_NAME"}
+}
+
+func writeReport(report *azdoctor.Report) error {
+	report.Write(os.Stdout)
+	return report.Err()
+}
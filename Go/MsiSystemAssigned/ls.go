@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/samkreter/container-instance-examples/Go/MsiSystemAssigned/azstorage"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+)
+
+// runList prints the blobs of a container, one virtual directory level at a
+// time unless -recursive is set
+func runList(args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	container := fs.String("container", "", "container to list, the SAS's container or "+containerName+" when empty")
+	recursive := fs.Bool("recursive", false, "list every blob below the prefix instead of one directory level")
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: getblob ls [flags] [prefix]")
+	}
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown output format '%s'", *format)
+	}
+
+	env, err := azenv.FromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	azStorage, err := newStorageClient(env)
+	if err != nil {
+		return err
+	}
+
+	if *container == "" {
+		*container = defaultContainer(azStorage)
+	}
+
+	opts := azstorage.ListOptions{Prefix: fs.Arg(0)}
+	if !*recursive {
+		opts.Delimiter = "/"
+	}
+
+	items, err := azStorage.ListBlobs(context.Background(), *container, opts)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(items)
+	}
+
+	return writeListing(os.Stdout, items)
+}
+
+// writeListing prints the blobs as a table, directories only have a name
+func writeListing(w io.Writer, items []azstorage.BlobItem) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tLAST MODIFIED\tCONTENT TYPE\tETAG\tMETADATA")
+	for _, item := range items {
+		if item.Dir {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\n", item.Name)
+			continue
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", item.Name, item.Size, item.LastModified.Format(time.RFC3339),
+			orDash(item.ContentType), item.ETag, orDash(formatMetadata(item.Metadata)))
+	}
+
+	return tw.Flush()
+}
+
+// formatMetadata prints metadata as key=value pairs sorted by key
+func formatMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+"="+value)
+	}
+
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
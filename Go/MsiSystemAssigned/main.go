@@ -4,55 +4,202 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/samkreter/container-instance-examples/Go/MsiSystemAssigned/azstorage"
+	"github.com/samkreter/container-instance-examples/Go/shared/azcred"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
 )
 
 const (
-	getBlobRetryCount = 30
+	containerName = "democontainer"
+	blobName      = "kubernetes-acsiiart.txt"
 )
 
 func main() {
-	subID := getEnv("SUBID")
-	resourceGroup := getEnv("RESOURCE_GROUP")
-	storageAccountName := getEnv("ACCOUNT_NAME")
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "doctor":
+			err = runDoctor()
+		case "ls":
+			err = runList(os.Args[2:])
+		case "sas":
+			err = runSAS(os.Args[2:])
+		case "sync":
+			err = runSync(os.Args[2:])
+		case "upload":
+			err = runUpload(os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command '%s', use doctor, ls, sas, sync or upload", os.Args[1])
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	azStorage, err := azstorage.NewClient(storageAccountName, resourceGroup, subID, "")
+	env, err := azenv.FromEnvironment()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
-	defer cancel()
+	azStorage, err := newStorageClient(env)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	count := 0
-	var blobContents string
-	for {
-		blobContents, err = azStorage.GetBlob(ctx, "democontainer", "kubernetes-acsiiart.txt")
-		if err == nil {
-			break
-		}
+	// The account key stats are published at /debug/vars
+	if addr, ok := os.LookupEnv("METRICS_ADDR"); ok {
+		go func() {
+			log.Println(http.ListenAndServe(addr, nil))
+		}()
+	}
 
-		if count >= getBlobRetryCount {
-			log.Fatal("Exceded retry attempts")
-		}
+	container := defaultContainer(azStorage)
 
-		log.Println("Retrying get blob")
-		time.Sleep(time.Second * 3)
+	if path, ok := os.LookupEnv("DOWNLOAD_PATH"); ok {
+		if err := downloadToFile(azStorage, container, path); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		count++
+	// Requests are retried while the identity comes up and its role assignment propagates
+	blobContents, err := azStorage.GetBlob(context.Background(), container, blobName)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	log.Println("Download Blob Contents:")
 	time.Sleep(time.Second * 1)
 	fmt.Println(blobContents)
 
+	// Keep a local copy fresh, e.g. a config file in a mounted volume
+	if path, ok := os.LookupEnv("WATCH_PATH"); ok {
+		if err := watchBlob(azStorage, container, path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	blocker := make(chan struct{})
 	<-blocker
 }
 
+// watchBlob writes the blob to path and replaces the file whenever the blob
+// changes, polling every WATCH_INTERVAL. It only returns if the first write fails.
+func watchBlob(azStorage *azstorage.Client, container, path string) error {
+	interval := time.Second * 30
+	if val, ok := os.LookupEnv("WATCH_INTERVAL"); ok {
+		var err error
+		if interval, err = time.ParseDuration(val); err != nil {
+			return fmt.Errorf("invalid WATCH_INTERVAL: %v", err)
+		}
+	}
+
+	watcher := azStorage.NewBlobWatcher(container, blobName, path, interval)
+	watcher.OnChange(func(version azstorage.BlobVersion) {
+		log.Printf("%s holds ETag %s (%d bytes, modified %s)", path, version.ETag, version.Size, version.LastModified.Format(time.RFC3339))
+	})
+
+	if _, err := watcher.Poll(context.Background()); err != nil {
+		return err
+	}
+
+	watcher.Run(context.Background())
+	return nil
+}
+
+// downloadToFile downloads the blob to path in parallel ranges, logging every
+// 10%. Rerunning after a failure resumes the download.
+func downloadToFile(azStorage *azstorage.Client, container, path string) error {
+	lastLogged := int64(-1)
+	opts := azstorage.DownloadOptions{
+		Progress: func(downloaded, total int64) {
+			percent := int64(100)
+			if total > 0 {
+				percent = downloaded * 100 / total
+			}
+
+			if percent/10 != lastLogged {
+				lastLogged = percent / 10
+				log.Printf("Downloaded %d of %d bytes (%d%%)", downloaded, total, percent)
+			}
+		},
+	}
+
+	size, err := azStorage.DownloadBlobToFile(context.Background(), container, blobName, path, opts)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Saved %d bytes to %s", size, path)
+	return nil
+}
+
+// newStorageClient creates a storage client that retries with the policy
+// configured in the environment
+func newStorageClient(env azure.Environment) (*azstorage.Client, error) {
+	policy, err := azretry.FromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	return newStorageClientWithPolicy(env, policy)
+}
+
+// newStorageClientWithPolicy creates a storage client from a connection
+// string, a SAS URL, a keyvault secret holding either, or else the account's
+// resource manager coordinates. The client and the keyvault client reading
+// the secret retry with the policy.
+func newStorageClientWithPolicy(env azure.Environment, policy azretry.Policy) (*azstorage.Client, error) {
+	c, err := newStorageClientFromEnv(env, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	c.RetryPolicy = policy
+	return c, nil
+}
+
+func newStorageClientFromEnv(env azure.Environment, policy azretry.Policy) (*azstorage.Client, error) {
+	if connectionString, ok := os.LookupEnv("AZURE_STORAGE_CONNECTION_STRING"); ok {
+		return azstorage.NewClientFromConnectionString(env, connectionString)
+	}
+
+	if sasURL, ok := os.LookupEnv("AZURE_STORAGE_SAS_URL"); ok {
+		return azstorage.NewClientFromSASURL(env, sasURL)
+	}
+
+	if secretRef, ok := os.LookupEnv("AZURE_STORAGE_SECRET"); ok {
+		chain, err := azcred.FromEnvironment(env, "")
+		if err != nil {
+			return nil, err
+		}
+		keyClient := azkeyvault.NewKeyVaultClientWithPolicy(env, os.Getenv("KEYVAULT_VAULT_NAME"), chain, policy)
+
+		// The secret is retried while the identity comes up and its access policy propagates
+		return azstorage.NewClientFromSecret(context.Background(), env, keyClient, secretRef)
+	}
+
+	return azstorage.NewClientWithEnvironment(env, getEnv("ACCOUNT_NAME"), getEnv("RESOURCE_GROUP"), getEnv("SUBID"), "")
+}
+
+// defaultContainer is the container of a container SAS, which only grants
+// access to its own container, or else the demo container
+func defaultContainer(azStorage *azstorage.Client) string {
+	if azStorage.DefaultContainerName != "" {
+		return azStorage.DefaultContainerName
+	}
+
+	return containerName
+}
+
 func getEnv(envName string) string {
 	val, ok := os.LookupEnv(envName)
 	if !ok {
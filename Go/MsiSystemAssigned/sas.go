@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/samkreter/container-instance-examples/Go/MsiSystemAssigned/azstorage"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+)
+
+// runSAS prints a SAS URL for a blob, or for the whole container when no blob
+// is given, e.g. to hand out a short lived download link
+func runSAS(args []string) error {
+	fs := flag.NewFlagSet("sas", flag.ExitOnError)
+	container := fs.String("container", "", "container of the SAS, the demo container when empty")
+	permissions := fs.String("permissions", "r", "permissions to grant: any of racwd, and l for a container")
+	start := fs.String("start", "", "when the SAS becomes valid as RFC3339 or a duration from now, right away when empty")
+	expiry := fs.String("expiry", "1h", "when the SAS expires as RFC3339 or a duration from now")
+	ipRange := fs.String("ip", "", "IPv4 address or range like 10.0.0.1-10.0.0.255 allowed to use the SAS")
+	httpsOnly := fs.Bool("https-only", true, "refuse requests over plain HTTP")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: getblob sas [flags] [blob]")
+	}
+
+	opts := azstorage.SASOptions{
+		Permissions: *permissions,
+		IPRange:     *ipRange,
+		HTTPSOnly:   *httpsOnly,
+	}
+
+	var err error
+	if opts.Start, err = parseTime(*start); err != nil {
+		return fmt.Errorf("invalid -start: %v", err)
+	}
+	if opts.Expiry, err = parseTime(*expiry); err != nil {
+		return fmt.Errorf("invalid -expiry: %v", err)
+	}
+
+	env, err := azenv.FromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	azStorage, err := newStorageClient(env)
+	if err != nil {
+		return err
+	}
+
+	if val, ok := os.LookupEnv(azstorage.SASMaxExpiryVar); ok {
+		if azStorage.SASMaxExpiry, err = time.ParseDuration(val); err != nil {
+			return fmt.Errorf("invalid %s: %v", azstorage.SASMaxExpiryVar, err)
+		}
+	}
+
+	if *container == "" {
+		*container = defaultContainer(azStorage)
+	}
+
+	ctx := context.Background()
+	var sasURL string
+	if blob := fs.Arg(0); blob != "" {
+		sasURL, err = azStorage.BlobSASURL(ctx, *container, blob, opts)
+	} else {
+		sasURL, err = azStorage.ContainerSASURL(ctx, *container, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(sasURL)
+	return nil
+}
+
+// parseTime reads an RFC3339 time or a duration from now, the zero time when empty
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(d).UTC(), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/samkreter/container-instance-examples/Go/MsiSystemAssigned/azstorage"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+)
+
+// runSync mirrors a local directory up to a container prefix or down from it,
+// e.g. to fetch a dataset before a job and push its outputs afterwards
+func runSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	container := fs.String("container", "", "container to sync with, the SAS's container or "+containerName+" when empty")
+	del := fs.Bool("delete", false, "delete the files or blobs on the destination that aren't on the source")
+	dryRun := fs.Bool("dry-run", false, "print the plan without transferring or deleting anything")
+	concurrency := fs.Int("concurrency", azstorage.DefaultSyncConcurrency, "number of files transferred at once")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 || fs.NArg() > 3 || (fs.Arg(0) != "up" && fs.Arg(0) != "down") {
+		return fmt.Errorf("usage: getblob sync [flags] up|down <dir> [prefix]")
+	}
+
+	direction, dir, prefix := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+
+	env, err := azenv.FromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	azStorage, err := newStorageClient(env)
+	if err != nil {
+		return err
+	}
+
+	if *container == "" {
+		*container = defaultContainer(azStorage)
+	}
+
+	opts := azstorage.SyncOptions{
+		Concurrency: *concurrency,
+		Delete:      *del,
+		DryRun:      *dryRun,
+	}
+
+	ctx := context.Background()
+	var result *azstorage.SyncResult
+	if direction == "up" {
+		result, err = azStorage.SyncUp(ctx, dir, *container, prefix, opts)
+	} else {
+		result, err = azStorage.SyncDown(ctx, *container, prefix, dir, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "OP\tNAME\tSIZE\tREASON")
+		for _, action := range result.Actions {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", action.Op, action.Name, action.Size, action.Reason)
+		}
+		tw.Flush()
+
+		log.Printf("Dry run: %d to sync, %d unchanged", len(result.Actions), result.Unchanged)
+		return nil
+	}
+
+	for _, action := range result.Actions {
+		if action.Err != nil {
+			log.Printf("Failed to %s %s: %v", action.Op, action.Name, action.Err)
+			continue
+		}
+		log.Printf("%s %s (%s)", action.Op, action.Name, action.Reason)
+	}
+
+	failed := len(result.Failed())
+	log.Printf("Synced %d, %d unchanged, %d failed", len(result.Actions)-failed, result.Unchanged, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to sync", failed, len(result.Actions))
+	}
+
+	return nil
+}
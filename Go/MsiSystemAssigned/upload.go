@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/samkreter/container-instance-examples/Go/MsiSystemAssigned/azstorage"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+)
+
+// runUpload uploads a local file with the same identity and configuration
+// the download uses, e.g. to publish a job's results
+func runUpload(args []string) error {
+	metadata := metadataFlags{}
+
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	container := fs.String("container", "", "container to upload to, the SAS's container or "+containerName+" when empty")
+	contentType := fs.String("content-type", "", "content type of the blob, taken from the file extension when empty")
+	fs.Var(metadata, "metadata", "metadata to set on the blob as key=value, may be repeated")
+	noOverwrite := fs.Bool("no-overwrite", false, "fail when the blob already exists")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: getblob upload [flags] <file> [blob]")
+	}
+
+	path := fs.Arg(0)
+	name := filepath.Base(path)
+	if fs.NArg() == 2 {
+		name = fs.Arg(1)
+	}
+
+	env, err := azenv.FromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	azStorage, err := newStorageClient(env)
+	if err != nil {
+		return err
+	}
+
+	if *container == "" {
+		*container = defaultContainer(azStorage)
+	}
+
+	opts := azstorage.UploadOptions{
+		ContentType: *contentType,
+		Metadata:    metadata,
+		NoOverwrite: *noOverwrite,
+	}
+
+	size, err := azStorage.UploadFile(context.Background(), *container, name, path, opts)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Uploaded %d bytes to %s/%s", size, *container, name)
+	return nil
+}
+
+// metadataFlags collects repeated -metadata key=value flags
+type metadataFlags map[string]string
+
+func (m metadataFlags) String() string {
+	return fmt.Sprint(map[string]string(m))
+}
+
+func (m metadataFlags) Set(value string) error {
+	idx := strings.Index(value, "=")
+	if idx <= 0 {
+		return fmt.Errorf("expected key=value, got '%s'", value)
+	}
+
+	m[value[:idx]] = value[idx+1:]
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -19,11 +20,13 @@ type User struct {
 
 // DB is the database wrapper for a mongodb connection
 type DB struct {
+	mu        sync.RWMutex
 	connURI   string
+	session   *mgo.Session
 	Container string
 }
 
-// NewDB creates a new database wrapper
+// NewDB creates a new database wrapper, the connection is dialed on first use
 func NewDB(connURI, container string) *DB {
 	return &DB{
 		connURI:   connURI,
@@ -31,10 +34,61 @@ func NewDB(connURI, container string) *DB {
 	}
 }
 
-func (db *DB) getConn() *mgo.Session {
-	dialInfo, err := mgo.ParseURL(db.connURI)
+// UpdateConnURI checks that the new connection string works and swaps in the
+// session dialed with it. Operations already in flight keep their copy of the
+// old session, and an unchanged connection string keeps the current session.
+func (db *DB) UpdateConnURI(connURI string) error {
+	db.mu.RLock()
+	unchanged := db.session != nil && db.connURI == connURI
+	db.mu.RUnlock()
+
+	if unchanged {
+		return nil
+	}
+
+	session, err := dial(connURI)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	db.mu.Lock()
+	old := db.session
+	db.connURI = connURI
+	db.session = session
+	db.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+
+	return nil
+}
+
+// getConn returns a copy of the current session, the caller must close it
+func (db *DB) getConn() (*mgo.Session, error) {
+	db.mu.RLock()
+	if db.session != nil {
+		session := db.session.Copy()
+		db.mu.RUnlock()
+		return session, nil
+	}
+	connURI := db.connURI
+	db.mu.RUnlock()
+
+	if err := db.UpdateConnURI(connURI); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.session.Copy(), nil
+}
+
+func dial(connURI string) (*mgo.Session, error) {
+	dialInfo, err := mgo.ParseURL(connURI)
+	if err != nil {
+		return nil, err
 	}
 
 	// //Below part is similar to above.
@@ -44,17 +98,20 @@ func (db *DB) getConn() *mgo.Session {
 
 	session, err := mgo.DialWithInfo(dialInfo)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	session.SetSafe(&mgo.Safe{})
 
-	return session
+	return session, nil
 }
 
 // InsertUsers inserts the passed in users into the database.
 func (db *DB) InsertUsers(users []User) error {
-	session := db.getConn()
+	session, err := db.getConn()
+	if err != nil {
+		return err
+	}
 	defer session.Close()
 
 	c := session.DB(db.Container).C(db.Container)
@@ -81,7 +138,10 @@ func (db *DB) InsertUsers(users []User) error {
 
 // GetUsers gets all of the users from the database.
 func (db *DB) GetUsers() ([]User, error) {
-	session := db.getConn()
+	session, err := db.getConn()
+	if err != nil {
+		return nil, err
+	}
 	defer session.Close()
 
 	c := session.DB(db.Container).C(db.Container)
@@ -89,7 +149,7 @@ func (db *DB) GetUsers() ([]User, error) {
 	log.Println("Getting Users from Databases")
 
 	var users []User
-	err := c.Find(bson.M{}).All(&users)
+	err = c.Find(bson.M{}).All(&users)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azdoctor"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// runDoctor checks the environment, identity, network and keyvault access
+// policy the app relies on and prints what to fix
+func runDoctor() error {
+	ctx := context.Background()
+	report := &azdoctor.Report{}
+	report.Add(azdoctor.Env("VAULT_NAME"))
+
+	res, chain := azdoctor.Credential(os.Getenv("MSI_CLIENTID"))
+	report.Add(res)
+
+	if chain != nil {
+		report.Add(azdoctor.IMDS(ctx, chain))
+		results, claims := azdoctor.Tokens(ctx, chain, "keyvault")
+		report.Add(results...)
+
+		if vaultName := os.Getenv("VAULT_NAME"); vaultName != "" {
+			report.Add(azdoctor.DNS(ctx, azenv.VaultURL(chain.Environment, vaultName)))
+
+			// Failures are reported as they happen instead of being ridden out
+			keyClient := azkeyvault.NewKeyVaultClientWithPolicy(chain.Environment, vaultName, chain, azretry.NoRetries)
+
+			secretRefs := []string{cosmosDBSecretRef()}
+			if certSecretRef, ok := os.LookupEnv("TLS_CERT_SECRET"); ok {
+				secretRefs = append(secretRefs, certSecretRef)
+			}
+
+			for _, ref := range secretRefs {
+				report.Add(azdoctor.SecretAccess(ctx, keyClient, vaultName, ref, claims["keyvault"]))
+			}
+		}
+	}
+
+	report.Write(os.Stdout)
+	return report.Err()
+}
@@ -2,61 +2,91 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
-	"github.com/Azure/go-autorest/autorest/azure"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
 )
 
 const (
-	getSecretRetires      = 10
-	cosmosDBURISecretName = "cosmosDBConnectionString"
+	cosmosDBURISecretName  = "cosmosDBConnectionString"
+	defaultRefreshInterval = time.Minute * 5
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		if err := runDoctor(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	vaultName, ok := os.LookupEnv("VAULT_NAME")
 	if !ok {
 		log.Fatal("VAULT_NAME must be set.")
 	}
 
-	clientID, ok := os.LookupEnv("MSI_CLIENTID")
-	if !ok {
-		log.Fatal("MSI_CLIENTID must be set.")
+	// The user assigned identity, by client ID, object_id=<id> or mi_res_id=<resource id>.
+	// Left empty the identity comes from AZURE_MSI_IDENTITY or is the system assigned one.
+	msiIdentity := os.Getenv("MSI_CLIENTID")
+
+	secretRef := cosmosDBSecretRef()
+
+	refreshInterval := defaultRefreshInterval
+	if val, ok := os.LookupEnv("SECRET_REFRESH_INTERVAL"); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			log.Fatalf("Invalid SECRET_REFRESH_INTERVAL: %v", err)
+		}
+		refreshInterval = d
 	}
 
-	keyClient, err := NewKeyVaultClient(vaultName, clientID)
+	env, err := azenv.FromEnvironment()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	count := 0
-	var dbURI string
-	for {
-		dbURI, err = keyClient.GetSecret(cosmosDBURISecretName)
-		if err != nil {
-			if count > getSecretRetires {
-				log.Fatalf("Failed to get secret within retries with err: %v", err)
-			}
-
-			log.Printf("Retrying GetSecret: %d", count)
-			count++
+	keyClient, err := azkeyvault.NewKeyVaultClientWithEnvironment(env, vaultName, msiIdentity)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			time.Sleep(time.Second)
-			continue
+	// Serve secrets from a cache so a keyvault outage doesn't take the app down with it
+	cache := keyClient.NewSecretCache(refreshInterval)
+	if snapshotPath, ok := os.LookupEnv("KEYVAULT_SNAPSHOT_PATH"); ok {
+		if err := useSnapshot(cache, snapshotPath); err != nil {
+			log.Fatal(err)
 		}
+	}
+
+	watcher := cache.NewSecretWatcher(secretRef, refreshInterval)
 
-		log.Println("Got DBURI")
-		break
+	// The keyvault client retries while MSI isn't ready, the identity's access
+	// policy propagates or keyvault is throttling
+	if _, err := watcher.Poll(context.Background()); err != nil {
+		log.Fatal(err)
 	}
+	log.Println("Got DBURI")
+
+	db := NewDB(watcher.Latest().Value, "users")
+
+	// Swap in the new connection string when the secret is rotated
+	watcher.OnChange(func(secret *azkeyvault.Secret) {
+		if err := db.UpdateConnURI(secret.Value); err != nil {
+			log.Printf("Failed to switch to connection string version '%s': %v", secret.Version, err)
+			return
+		}
+
+		log.Printf("Switched to connection string version '%s'", secret.Version)
+	})
+	go watcher.Run(context.Background())
 
-	db := NewDB(dbURI, "users")
 	users, err := db.GetUsers()
 	if err != nil {
 		log.Fatal(err)
@@ -68,16 +98,18 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-
-		users, err = db.GetUsers()
-		if err != nil {
-			log.Fatal(err)
-		}
 	}
 
 	tmpl := template.Must(template.ParseFiles("index.html"))
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		users, err := db.GetUsers()
+		if err != nil {
+			log.Printf("Failed to get users: %v", err)
+			http.Error(w, "Failed to get users", http.StatusInternalServerError)
+			return
+		}
+
 		data := IndexPageData{
 			PageTitle: "All the Users",
 			Users:     users,
@@ -85,53 +117,51 @@ func main() {
 		tmpl.Execute(w, data)
 	})
 
-	log.Println("Serving on port 80")
-	log.Fatal(http.ListenAndServe("0.0.0.0:80", nil))
-}
+	httpPort := getEnvDefault("HTTP_PORT", "80")
+	if certSecretRef, ok := os.LookupEnv("TLS_CERT_SECRET"); ok {
+		log.Fatal(serveTLS(cache, certSecretRef, refreshInterval, httpPort, getEnvDefault("HTTPS_PORT", "443")))
+	}
 
-// IndexPageData holds the data to populate index.html
-type IndexPageData struct {
-	PageTitle string
-	Users     []User
+	log.Printf("Serving on port %s", httpPort)
+	log.Fatal(http.ListenAndServe("0.0.0.0:"+httpPort, nil))
 }
 
-// KeyVault holds the information for a keyvault instance
-type KeyVault struct {
-	client   *keyvault.BaseClient
-	vaultURL string
+// cosmosDBSecretRef returns the connection string's secret reference. It can
+// be pinned to a version so rollbacks restore the matching credential.
+func cosmosDBSecretRef() string {
+	if version, ok := os.LookupEnv("COSMOSDB_SECRET_VERSION"); ok {
+		return cosmosDBURISecretName + "@" + version
+	}
+
+	return cosmosDBURISecretName
 }
 
-// NewKeyVaultClient creates a new keyvault client
-func NewKeyVaultClient(vaultName, clientID string) (*KeyVault, error) {
-	msiKeyConfig := &auth.MSIConfig{
-		Resource: strings.TrimSuffix(azure.PublicCloud.KeyVaultEndpoint, "/"),
-		ClientID: clientID,
+// useSnapshot keeps the last known good secrets in an encrypted file, so a
+// restarted container comes up even while keyvault can't be reached
+func useSnapshot(cache *azkeyvault.SecretCache, snapshotPath string) error {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("KEYVAULT_SNAPSHOT_KEY"))
+	if err != nil {
+		return fmt.Errorf("KEYVAULT_SNAPSHOT_KEY must be a base64 encoded 32 byte key: %v", err)
 	}
 
-	auth, err := msiKeyConfig.Authorizer()
+	snapshot, err := azkeyvault.NewSnapshot(snapshotPath, key)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	keyClient := keyvault.New()
-	keyClient.Authorizer = auth
+	return cache.UseSnapshot(snapshot)
+}
 
-	k := &KeyVault{
-		vaultURL: fmt.Sprintf("https://%s.%s", vaultName, azure.PublicCloud.KeyVaultDNSSuffix),
-		client:   &keyClient,
+func getEnvDefault(envName, defaultValue string) string {
+	if val, ok := os.LookupEnv(envName); ok {
+		return val
 	}
 
-	return k, nil
+	return defaultValue
 }
 
-// GetSecret retrieves a secret from keyvault
-func (k *KeyVault) GetSecret(keyName string) (string, error) {
-	ctx := context.Background()
-
-	keyBundle, err := k.client.GetSecret(ctx, k.vaultURL, keyName, "")
-	if err != nil {
-		return "", err
-	}
-
-	return *keyBundle.Value, nil
+// IndexPageData holds the data to populate index.html
+type IndexPageData struct {
+	PageTitle string
+	Users     []User
 }
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+	"golang.org/x/crypto/pkcs12"
+)
+
+const (
+	pfxContentType = "application/x-pkcs12"
+	pemContentType = "application/x-pem-file"
+)
+
+// CertificateStore holds the serving certificate and swaps it when a new
+// version of the keyvault secret backing it shows up.
+type CertificateStore struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewCertificateStore creates a store that follows the watched certificate secret
+func NewCertificateStore(watcher *azkeyvault.SecretWatcher) (*CertificateStore, error) {
+	store := &CertificateStore{}
+
+	secret := watcher.Latest()
+	if secret == nil {
+		return nil, fmt.Errorf("certificate secret has not been fetched yet")
+	}
+
+	if err := store.Update(secret); err != nil {
+		return nil, err
+	}
+
+	watcher.OnChange(func(secret *azkeyvault.Secret) {
+		if err := store.Update(secret); err != nil {
+			log.Printf("Failed to load certificate version '%s', keeping the current one: %v", secret.Version, err)
+			return
+		}
+
+		log.Printf("Loaded certificate version '%s'", secret.Version)
+	})
+
+	return store, nil
+}
+
+// Update parses the certificate secret and makes it the serving certificate
+func (s *CertificateStore) Update(secret *azkeyvault.Secret) error {
+	cert, err := parseCertificate(secret)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.cert = cert
+	s.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (s *CertificateStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cert, nil
+}
+
+// serveTLS serves the default mux over HTTPS with the certificate from the
+// keyvault secret and redirects plain HTTP to it. New certificate versions
+// are picked up on the refresh interval without a restart.
+func serveTLS(cache *azkeyvault.SecretCache, certSecretRef string, refreshInterval time.Duration, httpPort, httpsPort string) error {
+	watcher := cache.NewSecretWatcher(certSecretRef, refreshInterval)
+	if _, err := watcher.Poll(context.Background()); err != nil {
+		return err
+	}
+
+	store, err := NewCertificateStore(watcher)
+	if err != nil {
+		return err
+	}
+	go watcher.Run(context.Background())
+
+	go func() {
+		log.Printf("Redirecting HTTP on port %s to HTTPS", httpPort)
+		log.Fatal(http.ListenAndServe("0.0.0.0:"+httpPort, redirectToHTTPS(httpsPort)))
+	}()
+
+	server := &http.Server{
+		Addr: "0.0.0.0:" + httpsPort,
+		TLSConfig: &tls.Config{
+			GetCertificate: store.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		},
+	}
+
+	log.Printf("Serving HTTPS on port %s", httpsPort)
+	return server.ListenAndServeTLS("", "")
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same path on the HTTPS port
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+// parseCertificate reads a certificate and private key from a keyvault
+// secret. Certificates stored in keyvault are exposed as secrets holding
+// either a base64 encoded PFX or a PEM bundle, marked by the content type.
+func parseCertificate(secret *azkeyvault.Secret) (*tls.Certificate, error) {
+	switch secret.ContentType {
+	case pemContentType:
+		cert, err := tls.X509KeyPair([]byte(secret.Value), []byte(secret.Value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PEM certificate: %v", err)
+		}
+		return &cert, nil
+
+	case pfxContentType, "":
+		pfx, err := base64.StdEncoding.DecodeString(secret.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PFX certificate: %v", err)
+		}
+		return parsePFX(pfx)
+	}
+
+	return nil, fmt.Errorf("unsupported certificate content type '%s'", secret.ContentType)
+}
+
+func parsePFX(pfx []byte) (*tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(pfx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PFX certificate: %v", err)
+	}
+
+	cert := &tls.Certificate{}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, c)
+		case "PRIVATE KEY":
+			cert.PrivateKey, err = parsePrivateKey(block)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cert.PrivateKey == nil || len(certs) == 0 {
+		return nil, fmt.Errorf("PFX certificate must contain a certificate and a private key")
+	}
+
+	// The leaf certificate has to come first in the chain, PFX files don't guarantee the order
+	pub := publicKey(cert.PrivateKey)
+	for _, c := range certs {
+		if reflect.DeepEqual(c.PublicKey, pub) {
+			cert.Certificate = append(cert.Certificate, c.Raw)
+			cert.Leaf = c
+			break
+		}
+	}
+	if cert.Leaf == nil {
+		return nil, fmt.Errorf("PFX certificate does not contain the certificate for its private key")
+	}
+	for _, c := range certs {
+		if c != cert.Leaf {
+			cert.Certificate = append(cert.Certificate, c.Raw)
+		}
+	}
+
+	return cert, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported private key in PFX certificate")
+}
+
+func publicKey(key crypto.PrivateKey) crypto.PublicKey {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+)
+
+// testPFX holds a localhost certificate signed by a test CA and the
+// certificate's EC key, exported by openssl with an empty password
+const testPFX = "MIIE0gIBAzCCBJgGCSqGSIb3DQEHAaCCBIkEggSFMIIEgTCCA3cGCSqGSIb3DQEHBqCCA2gwggNk" +
+	"AgEAMIIDXQYJKoZIhvcNAQcBMBwGCiqGSIb3DQEMAQMwDgQI7wCMjakIqOQCAggAgIIDMN+/7faK" +
+	"iJpgPUQ7SHuKVzrF1pwy43PkWOmmxyM6rJQIsXZqo/n+bL20qGu+p1DQSbaMSg/r+YZygCovMj6I" +
+	"UjMUWLb5fRUCm5zb7s0FigygXF0scEVO64kY6Wk9mTKLeK8Hv1XF4eOF0U8B6BX3QF51ClZhufsc" +
+	"0vBFpuQWxvdcBKBRbK0KV0ielgGxHjsWSvWpJ//C3cKggnSmYUFAkwqnH/NLjzTPTbmB2GwLrDmm" +
+	"YYIeDelNrtAnohWSqaGWiBCkt7myP3oR4Fuva6xx4N0V2lpxpSOkUx5NgKrBtbvXTPGwQfkWEo3l" +
+	"XDA0wH/yKcH9qtpAAeBjk4ZVeWN98FGz06sI370NXHEjsE5TL0qpgV/S3hs5pTfW7cJ9ZSY0vz6i" +
+	"Dgtam5Rdoqknl1jGvKaTwx7zN8wWrvWqH6vgF1BBD0sJ8o4XgK8ktlU6T7yRw58kLDGhfnsz7GYt" +
+	"f79qKIw9N1Ov4zVcUWduKTd3zBhvBOOW5y1O0T+tOQ17bDoYvAQxhzZWovWMPo67SzDDZ+Y29V/j" +
+	"p8s3zMD9RxYLRiUYG40qXNyMaCGS9zzR+ui3WPuCvMoktpvGYhdAmP2tDxs/lWMByhlH/dmdxc+M" +
+	"qL9SEbF2KZxI2myIWZcP8OoN0JtkPRpvHlcHMj9sM3GRPQhORKEGunIFCcZFDWZQf+89JoezdasK" +
+	"OOpheHuc5YxW7FEURqGNmTj3dhOdAlrUnJk+Pgvj13sqClqGdtDzpGeLCul/muKFGuloWq/pvH4O" +
+	"Mig//DozBM/a5x0JkYJYbGy50x7+WC+ye9VwHwPgFsuu0Wsbe+/iP7wNONzZoIg/htoANKJxPEUK" +
+	"GaLEENdAFk7OKDOSps0vbKp8ZobZPRR1PvMt0f6EmeqiaTRv0GQmZqHXgN74a9BWtF3WVOtvudE3" +
+	"FCn918jN9T8TqU55mOL2vBDLvUBPlndzHMmxj7Xw8iYe3eevEFtmA0sF7GLmF5MjFmRpXg4ADURb" +
+	"28xsppESLS2UvarbwAE9MtlD8K8ALuTb0xrvL8qFidMz33NKmTpyptu210aORMv3Tv+O5oX5YK3r" +
+	"/M1ODujl7BWfwE4sLDCCAQIGCSqGSIb3DQEHAaCB9ASB8TCB7jCB6wYLKoZIhvcNAQwKAQKggbQw" +
+	"gbEwHAYKKoZIhvcNAQwBAzAOBAj+ReHe6pq8EwICCAAEgZAVJeeGxuWnaEgdL6MDZ535HX03glQm" +
+	"1Xbm50ZisMizsInKsnO/FhET5gkcgJiZC7DbFFQW2f3WkZh6Dua89qv3v1fwmqrgjaM357uwqfYt" +
+	"vS0G4PTPPiXLS4gcTN8eNHVYwUdWjqYplfhsbZqI908urGTg0Ric0E8vZrTLegaadJkht8wMb15N" +
+	"KF7ynTVnFWoxJTAjBgkqhkiG9w0BCRUxFgQUYqznov0mk4bgugv0vsz0uB5ntz8wMTAhMAkGBSsO" +
+	"AwIaBQAEFNFs3JOFCBgk0833EG4BYWItj9tzBAigkCKIMmoaKAICCAA="
+
+// newTestPEM returns a self signed certificate and its key as a PEM bundle
+func newTestPEM(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func newCertSecret(contentType, value string) *azkeyvault.Secret {
+	return &azkeyvault.Secret{
+		SecretProperties: azkeyvault.SecretProperties{Name: "tls-cert", Version: "v1", ContentType: contentType},
+		Value:            value,
+	}
+}
+
+func TestParseCertificatePFX(t *testing.T) {
+	for _, contentType := range []string{pfxContentType, ""} {
+		cert, err := parseCertificate(newCertSecret(contentType, testPFX))
+		if err != nil {
+			t.Fatalf("content type %q: parseCertificate failed: %v", contentType, err)
+		}
+
+		if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "localhost" {
+			t.Fatalf("content type %q: leaf = %v, want the localhost certificate", contentType, cert.Leaf)
+		}
+
+		if len(cert.Certificate) != 2 {
+			t.Fatalf("content type %q: chain has %d certificates, want 2", contentType, len(cert.Certificate))
+		}
+
+		if string(cert.Certificate[0]) != string(cert.Leaf.Raw) {
+			t.Errorf("content type %q: the leaf should come first in the chain", contentType)
+		}
+
+		if !publicKeysEqual(publicKey(cert.PrivateKey), cert.Leaf.PublicKey) {
+			t.Errorf("content type %q: private key does not match the leaf", contentType)
+		}
+	}
+}
+
+func TestParseCertificatePEM(t *testing.T) {
+	cert, err := parseCertificate(newCertSecret(pemContentType, newTestPEM(t)))
+	if err != nil {
+		t.Fatalf("parseCertificate failed: %v", err)
+	}
+
+	if len(cert.Certificate) != 1 || cert.PrivateKey == nil {
+		t.Errorf("parseCertificate = %d certificates, key %v", len(cert.Certificate), cert.PrivateKey != nil)
+	}
+}
+
+func TestParseCertificateErrors(t *testing.T) {
+	pfx, err := base64.StdEncoding.DecodeString(testPFX)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []*azkeyvault.Secret{
+		newCertSecret("text/plain", testPFX),
+		newCertSecret(pfxContentType, "not base64!"),
+		newCertSecret(pfxContentType, base64.StdEncoding.EncodeToString(pfx[:len(pfx)/2])),
+		newCertSecret(pemContentType, testPFX),
+		newCertSecret(pemContentType, newTestPEM(t)[:200]),
+	}
+
+	for _, secret := range tests {
+		if _, err := parseCertificate(secret); err == nil {
+			t.Errorf("parseCertificate(%s, %.20q...) should fail", secret.ContentType, secret.Value)
+		}
+	}
+}
+
+func TestCertificateStoreUpdate(t *testing.T) {
+	store := &CertificateStore{}
+
+	if err := store.Update(newCertSecret(pfxContentType, testPFX)); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	first, _ := store.GetCertificate(nil)
+
+	// A broken version must leave the current certificate in place
+	if err := store.Update(newCertSecret(pfxContentType, "broken")); err == nil {
+		t.Fatal("Update should fail on a broken certificate")
+	}
+
+	if cert, _ := store.GetCertificate(nil); cert != first {
+		t.Error("a failed update replaced the serving certificate")
+	}
+}
+
+func publicKeysEqual(a, b interface{}) bool {
+	ka, ok := a.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+	kb, ok := b.(*ecdsa.PublicKey)
+	if !ok {
+		return false
+	}
+
+	return ka.X.Cmp(kb.X) == 0 && ka.Y.Cmp(kb.Y) == 0
+}
@@ -0,0 +1,306 @@
+package azcred
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// The credential methods a chain can try
+const (
+	MSI               = "msi"
+	ClientSecret      = "client-secret"
+	ClientCertificate = "client-certificate"
+	CLI               = "cli"
+)
+
+const (
+	// ChainVar is a comma separated list of the methods to try in order, e.g. msi,client-secret
+	ChainVar = "AZURE_CREDENTIAL_CHAIN"
+
+	// The service principal settings, the same variables auth.NewAuthorizerFromEnvironment reads
+	ClientIDVar            = "AZURE_CLIENT_ID"
+	ClientSecretVar        = "AZURE_CLIENT_SECRET"
+	TenantIDVar            = "AZURE_TENANT_ID"
+	CertificatePathVar     = "AZURE_CERTIFICATE_PATH"
+	CertificatePasswordVar = "AZURE_CERTIFICATE_PASSWORD"
+
+	// probeTimeout bounds each attempt to get a token while walking the chain,
+	// so an unreachable IMDS endpoint on a laptop doesn't stall the programs
+	probeTimeout = time.Second * 10
+)
+
+// DefaultChain is the order methods are tried in when the chain isn't configured
+var DefaultChain = []string{MSI, ClientSecret, ClientCertificate, CLI}
+
+// tokenSource is a token provider the chain can select and keep refreshing
+type tokenSource interface {
+	adal.OAuthTokenProvider
+	adal.RefresherWithContext
+}
+
+// Chain tries a list of credential methods in order and uses the first one
+// that gets a token
+type Chain struct {
+	Methods     []string
+	Environment azure.Environment
+
+	// Identity is the managed identity used for resources without an entry in Identities
+	Identity Identity
+	// Identities maps resource URIs to the managed identity used for them
+	Identities map[string]Identity
+
+	mu       sync.Mutex
+	selected string
+}
+
+// NewChain creates a chain trying the methods in order, DefaultChain if none
+// are given. The identity is the managed identity MSI uses.
+func NewChain(env azure.Environment, identity Identity, methods ...string) (*Chain, error) {
+	if len(methods) == 0 {
+		methods = DefaultChain
+	}
+
+	for _, m := range methods {
+		if !validMethod(m) {
+			return nil, fmt.Errorf("unknown credential method '%s', use %s", m, strings.Join(DefaultChain, ", "))
+		}
+	}
+
+	return &Chain{
+		Methods:     methods,
+		Environment: env,
+		Identity:    identity,
+		Identities:  map[string]Identity{},
+	}, nil
+}
+
+// FromEnvironment creates a chain with the order and allowed methods from
+// AZURE_CREDENTIAL_CHAIN. The managed identity is the one given, falling back
+// to AZURE_MSI_IDENTITY, and AZURE_MSI_IDENTITIES picks identities per resource.
+func FromEnvironment(env azure.Environment, msiIdentity string) (*Chain, error) {
+	var methods []string
+	for _, m := range strings.Split(os.Getenv(ChainVar), ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			methods = append(methods, strings.ToLower(m))
+		}
+	}
+
+	if msiIdentity == "" {
+		msiIdentity = os.Getenv(IdentityVar)
+	}
+
+	identity, err := ParseIdentity(msiIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := NewChain(env, identity, methods...)
+	if err != nil {
+		return nil, err
+	}
+
+	chain.Identities, err = ParseIdentities(env, os.Getenv(IdentitiesVar))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", IdentitiesVar, err)
+	}
+
+	return chain, nil
+}
+
+// IdentityFor returns the managed identity used to get tokens for the resource
+func (c *Chain) IdentityFor(resource string) Identity {
+	if id, ok := c.Identities[normalizeResource(resource)]; ok {
+		return id
+	}
+
+	return c.Identity
+}
+
+// Authorizer returns an authorizer for the resource. The chain is walked on
+// the first request rather than here, so a client can be created before
+// the identity is available.
+func (c *Chain) Authorizer(resource string) autorest.Authorizer {
+	return autorest.NewBearerAuthorizer(&chainToken{chain: c, resource: resource})
+}
+
+// Token walks the chain and returns a fresh token provider for the resource
+// along with the method that produced it
+func (c *Chain) Token(ctx context.Context, resource string) (adal.OAuthTokenProvider, string, error) {
+	source, method, err := c.acquire(ctx, resource)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return source, method, nil
+}
+
+// acquire tries each method in order. The method that worked last time is
+// tried first, so other resources don't pay for the failed probes again.
+func (c *Chain) acquire(ctx context.Context, resource string) (tokenSource, string, error) {
+	c.mu.Lock()
+	methods := c.Methods
+	if c.selected != "" {
+		methods = append([]string{c.selected}, without(c.Methods, c.selected)...)
+	}
+	c.mu.Unlock()
+
+	chainErr := &chainError{resource: resource}
+	for _, method := range methods {
+		source, err := c.newSource(method, resource)
+		if err == nil {
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			err = source.RefreshWithContext(probeCtx)
+			cancel()
+		}
+
+		if err != nil {
+			chainErr.methods = append(chainErr.methods, method)
+			chainErr.errs = append(chainErr.errs, err)
+			continue
+		}
+
+		c.mu.Lock()
+		c.selected = method
+		c.mu.Unlock()
+
+		log.Printf("Authenticated with %s credentials for '%s'", method, resource)
+		return source, method, nil
+	}
+
+	return nil, "", chainErr
+}
+
+// chainError lists why every method in the chain failed
+type chainError struct {
+	resource string
+	methods  []string
+	errs     []error
+}
+
+func (e *chainError) Error() string {
+	failures := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		failures[i] = fmt.Sprintf("%s: %v", e.methods[i], err)
+	}
+
+	return fmt.Sprintf("no credential in the chain could get a token for '%s': %s", e.resource, strings.Join(failures, "; "))
+}
+
+// Response returns the response the first method in the chain failed with,
+// so the failure is retried according to the preferred method, e.g. MSI
+func (e *chainError) Response() *http.Response {
+	if len(e.errs) == 0 {
+		return nil
+	}
+
+	if r, ok := e.errs[0].(interface {
+		Response() *http.Response
+	}); ok {
+		return r.Response()
+	}
+
+	return nil
+}
+
+func (c *Chain) newSource(method, resource string) (tokenSource, error) {
+	switch method {
+	case MSI:
+		return newMSIToken(resource, c.IdentityFor(resource))
+	case ClientSecret:
+		return newClientSecretToken(c.Environment, resource)
+	case ClientCertificate:
+		return newClientCertificateToken(c.Environment, resource)
+	case CLI:
+		return newCLIToken(resource), nil
+	}
+
+	return nil, fmt.Errorf("unknown credential method '%s'", method)
+}
+
+// chainToken selects a method from the chain on first use and then keeps
+// refreshing the token from that method
+type chainToken struct {
+	chain    *Chain
+	resource string
+
+	mu     sync.Mutex
+	source tokenSource
+}
+
+func (t *chainToken) OAuthToken() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.source == nil {
+		return ""
+	}
+
+	return t.source.OAuthToken()
+}
+
+func (t *chainToken) EnsureFreshWithContext(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.source == nil {
+		source, _, err := t.chain.acquire(ctx, t.resource)
+		if err != nil {
+			return err
+		}
+		t.source = source
+		return nil
+	}
+
+	return t.source.EnsureFreshWithContext(ctx)
+}
+
+func (t *chainToken) RefreshWithContext(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.source == nil {
+		source, _, err := t.chain.acquire(ctx, t.resource)
+		if err != nil {
+			return err
+		}
+		t.source = source
+		return nil
+	}
+
+	return t.source.RefreshWithContext(ctx)
+}
+
+func (t *chainToken) RefreshExchangeWithContext(ctx context.Context, resource string) error {
+	return fmt.Errorf("exchanging a chained token for another resource is not supported, create an authorizer for '%s'", resource)
+}
+
+func validMethod(method string) bool {
+	for _, m := range DefaultChain {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+func without(methods []string, method string) []string {
+	var out []string
+	for _, m := range methods {
+		if m != method {
+			out = append(out, m)
+		}
+	}
+
+	return out
+}
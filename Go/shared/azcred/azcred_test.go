@@ -0,0 +1,158 @@
+package azcred
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// setEnv sets the variables for the test and returns a func restoring them
+func setEnv(vars map[string]string) func() {
+	old := map[string]string{}
+	for k, v := range vars {
+		old[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+
+	return func() {
+		for k, v := range old {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func TestNewChain(t *testing.T) {
+	chain, err := NewChain(azure.PublicCloud, Identity{})
+	if err != nil {
+		t.Fatalf("NewChain failed: %v", err)
+	}
+	if !reflect.DeepEqual(chain.Methods, DefaultChain) {
+		t.Errorf("methods = %v, want the default chain %v", chain.Methods, DefaultChain)
+	}
+
+	if _, err := NewChain(azure.PublicCloud, Identity{}, MSI, "password"); err == nil {
+		t.Error("an unknown method should be refused")
+	}
+}
+
+func TestFromEnvironment(t *testing.T) {
+	defer setEnv(map[string]string{ChainVar: " MSI , ,cli"})()
+
+	chain, err := FromEnvironment(azure.PublicCloud, "client-id")
+	if err != nil {
+		t.Fatalf("FromEnvironment failed: %v", err)
+	}
+
+	if want := []string{MSI, CLI}; !reflect.DeepEqual(chain.Methods, want) {
+		t.Errorf("methods = %v, want %v", chain.Methods, want)
+	}
+	if chain.Identity.ClientID != "client-id" {
+		t.Errorf("MSI identity = %s, want client_id=client-id", chain.Identity)
+	}
+
+	os.Setenv(ChainVar, "msi,device-code")
+	if _, err := FromEnvironment(azure.PublicCloud, ""); err == nil {
+		t.Error("an unknown method in the environment should be refused")
+	}
+}
+
+func TestChainNotConfigured(t *testing.T) {
+	defer setEnv(map[string]string{ClientIDVar: "", ClientSecretVar: "", CertificatePathVar: "", TenantIDVar: ""})()
+
+	chain, err := NewChain(azure.PublicCloud, Identity{}, ClientSecret, ClientCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = chain.Token(context.Background(), "https://vault.azure.net")
+	if err == nil {
+		t.Fatal("a chain without any configured method should fail")
+	}
+
+	for _, want := range []string{ClientSecret + ": not configured", ClientCertificate + ": not configured", ClientSecretVar, CertificatePathVar} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q should mention %q", err, want)
+		}
+	}
+}
+
+func TestWithout(t *testing.T) {
+	if got, want := without(DefaultChain, ClientSecret), []string{MSI, ClientCertificate, CLI}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without = %v, want %v", got, want)
+	}
+}
+
+// fakeAuthorizer sets a fixed header or fails like a BearerAuthorizer whose token can't be refreshed
+type fakeAuthorizer struct {
+	token string
+	err   error
+}
+
+func (a fakeAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			if a.err != nil {
+				return r, autorest.NewErrorWithError(a.err, "fakeAuthorizer", "WithAuthorization", nil, "failed")
+			}
+			return autorest.Prepare(r, autorest.WithHeader("Authorization", "Bearer "+a.token))
+		})
+	}
+}
+
+func TestAuthorizerToken(t *testing.T) {
+	token := &authorizerToken{authorizer: fakeAuthorizer{token: "abc"}}
+	if err := token.RefreshWithContext(context.Background()); err != nil {
+		t.Fatalf("RefreshWithContext failed: %v", err)
+	}
+	if got := token.OAuthToken(); got != "abc" {
+		t.Errorf("OAuthToken = %q, want abc", got)
+	}
+
+	refreshErr := errors.New("token endpoint unreachable")
+	token = &authorizerToken{authorizer: fakeAuthorizer{err: refreshErr}}
+	if err := token.EnsureFreshWithContext(context.Background()); err != refreshErr {
+		t.Errorf("EnsureFreshWithContext = %v, want the unwrapped refresh error", err)
+	}
+	if got := token.OAuthToken(); got != "" {
+		t.Errorf("OAuthToken after a failed refresh = %q, want none", got)
+	}
+}
+
+// responseError mimics adal's refresh errors, which carry the IMDS response
+type responseError struct {
+	resp *http.Response
+}
+
+func (e responseError) Error() string            { return "refresh failed" }
+func (e responseError) Response() *http.Response { return e.resp }
+
+func TestChainErrorResponse(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusBadRequest}
+
+	err := &chainError{
+		resource: "https://vault.azure.net",
+		methods:  []string{MSI, CLI},
+		errs:     []error{responseError{resp}, errors.New("az not found")},
+	}
+
+	if err.Response() != resp {
+		t.Error("the chain error should carry the first method's response")
+	}
+
+	want := "no credential in the chain could get a token for 'https://vault.azure.net': msi: refresh failed; cli: az not found"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+
+	err.errs[0] = errors.New("dial tcp: timeout")
+	if err.Response() != nil {
+		t.Error("a chain error without a response should return nil")
+	}
+}
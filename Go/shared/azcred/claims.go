@@ -0,0 +1,89 @@
+package azcred
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Claims holds the claims of an access token that identify who it was issued to
+type Claims struct {
+	ObjectID   string    `json:"oid,omitempty"`
+	AppID      string    `json:"appid,omitempty"`
+	TenantID   string    `json:"tid,omitempty"`
+	ResourceID string    `json:"xms_mirid,omitempty"`
+	Audience   string    `json:"aud,omitempty"`
+	Issuer     string    `json:"iss,omitempty"`
+	IssuedAt   time.Time `json:"iat"`
+	NotBefore  time.Time `json:"nbf"`
+	Expires    time.Time `json:"exp"`
+}
+
+// DecodeClaims reads the claims of an access token. The signature is not
+// verified, the claims are only used to explain which identity was used.
+func DecodeClaims(accessToken string) (*Claims, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(accessToken, claims); err != nil {
+		return nil, fmt.Errorf("failed to decode access token: %v", err)
+	}
+
+	return &Claims{
+		ObjectID:   stringClaim(claims, "oid"),
+		AppID:      stringClaim(claims, "appid"),
+		TenantID:   stringClaim(claims, "tid"),
+		ResourceID: stringClaim(claims, "xms_mirid"),
+		Audience:   stringClaim(claims, "aud"),
+		Issuer:     stringClaim(claims, "iss"),
+		IssuedAt:   timeClaim(claims, "iat"),
+		NotBefore:  timeClaim(claims, "nbf"),
+		Expires:    timeClaim(claims, "exp"),
+	}, nil
+}
+
+// Problems lists the ways the token doesn't match what was asked for. The
+// identity is the managed identity that was configured, system assigned when
+// none was.
+func (c *Claims) Problems(resource string, identity Identity) []string {
+	var problems []string
+
+	switch {
+	case identity.ClientID != "" && !strings.EqualFold(identity.ClientID, c.AppID):
+		problems = append(problems, fmt.Sprintf("the configured client ID '%s' doesn't match the token's appid '%s', another identity was used", identity.ClientID, c.AppID))
+	case identity.ObjectID != "" && !strings.EqualFold(identity.ObjectID, c.ObjectID):
+		problems = append(problems, fmt.Sprintf("the configured object ID '%s' doesn't match the token's oid '%s', another identity was used", identity.ObjectID, c.ObjectID))
+	case identity.ResourceID != "" && !strings.EqualFold(identity.ResourceID, c.ResourceID):
+		problems = append(problems, fmt.Sprintf("the configured resource ID '%s' doesn't match the token's xms_mirid '%s', another identity was used", identity.ResourceID, c.ResourceID))
+	}
+
+	if resource != "" && strings.TrimSuffix(c.Audience, "/") != strings.TrimSuffix(resource, "/") {
+		problems = append(problems, fmt.Sprintf("the token's audience '%s' doesn't match the resource '%s'", c.Audience, resource))
+	}
+
+	if !c.Expires.IsZero() && time.Now().After(c.Expires) {
+		problems = append(problems, fmt.Sprintf("the token expired at %s, check the container's clock", c.Expires.Format(time.RFC3339)))
+	}
+
+	if !c.NotBefore.IsZero() && time.Now().Before(c.NotBefore.Add(-time.Minute*5)) {
+		problems = append(problems, fmt.Sprintf("the token is not valid before %s, check the container's clock", c.NotBefore.Format(time.RFC3339)))
+	}
+
+	return problems
+}
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	if s, ok := claims[name].(string); ok {
+		return s
+	}
+
+	return ""
+}
+
+func timeClaim(claims jwt.MapClaims, name string) time.Time {
+	if f, ok := claims[name].(float64); ok {
+		return time.Unix(int64(f), 0).UTC()
+	}
+
+	return time.Time{}
+}
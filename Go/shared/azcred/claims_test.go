@@ -0,0 +1,123 @@
+package azcred
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// newTestToken signs the claims with a throwaway key, DecodeClaims doesn't verify it
+func newTestToken(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestDecodeClaims(t *testing.T) {
+	now := time.Now().Truncate(time.Second).UTC()
+
+	token := newTestToken(t, jwt.MapClaims{
+		"oid":       "object-id",
+		"appid":     "client-id",
+		"tid":       "tenant-id",
+		"xms_mirid": "/subscriptions/sub/resourcegroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+		"aud":       "https://vault.azure.net",
+		"iss":       "https://sts.windows.net/tenant-id/",
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"exp":       now.Add(time.Hour).Unix(),
+	})
+
+	claims, err := DecodeClaims(token)
+	if err != nil {
+		t.Fatalf("DecodeClaims failed: %v", err)
+	}
+
+	want := Claims{
+		ObjectID:   "object-id",
+		AppID:      "client-id",
+		TenantID:   "tenant-id",
+		ResourceID: "/subscriptions/sub/resourcegroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+		Audience:   "https://vault.azure.net",
+		Issuer:     "https://sts.windows.net/tenant-id/",
+		IssuedAt:   now,
+		NotBefore:  now,
+		Expires:    now.Add(time.Hour),
+	}
+	if *claims != want {
+		t.Errorf("DecodeClaims = %+v, want %+v", *claims, want)
+	}
+
+	claims, err = DecodeClaims(newTestToken(t, jwt.MapClaims{"oid": 42}))
+	if err != nil {
+		t.Fatalf("DecodeClaims failed: %v", err)
+	}
+	if claims.ObjectID != "" || !claims.Expires.IsZero() {
+		t.Errorf("claims of the wrong type or missing should be empty, got %+v", *claims)
+	}
+
+	if _, err := DecodeClaims("not-a-token"); err == nil {
+		t.Error("DecodeClaims should fail for a malformed token")
+	}
+}
+
+func TestClaimsProblems(t *testing.T) {
+	now := time.Now()
+	valid := Claims{
+		AppID:      "client-id",
+		ObjectID:   "object-id",
+		ResourceID: "/subscriptions/sub/resourcegroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+		Audience:   "https://vault.azure.net",
+		NotBefore:  now.Add(-time.Minute),
+		Expires:    now.Add(time.Hour),
+	}
+
+	expired := valid
+	expired.Expires = now.Add(-time.Minute)
+
+	early := valid
+	early.NotBefore = now.Add(time.Hour)
+
+	skewed := valid
+	skewed.NotBefore = now.Add(time.Minute)
+
+	tests := []struct {
+		name     string
+		claims   Claims
+		resource string
+		identity Identity
+		want     []string
+	}{
+		{"valid", valid, "https://vault.azure.net/", Identity{ClientID: "CLIENT-ID"}, nil},
+		{"no expectations", valid, "", Identity{}, nil},
+		{"matching object ID", valid, "", Identity{ObjectID: "object-id"}, nil},
+		{"matching resource ID", valid, "", Identity{ResourceID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id"}, nil},
+		{"other client ID", valid, "https://vault.azure.net", Identity{ClientID: "other-id"}, []string{"appid"}},
+		{"other object ID", valid, "", Identity{ObjectID: "other-id"}, []string{"oid"}},
+		{"other resource ID", valid, "", Identity{ResourceID: "/subscriptions/sub/resourcegroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/other"}, []string{"xms_mirid"}},
+		{"other audience", valid, "https://management.azure.com/", Identity{}, []string{"doesn't match the resource"}},
+		{"expired", expired, "", Identity{}, []string{"expired"}},
+		{"not yet valid", early, "", Identity{}, []string{"not valid before"}},
+		{"small clock skew", skewed, "", Identity{}, nil},
+		{"several", expired, "https://storage.azure.com/", Identity{ClientID: "other-id"}, []string{"another identity", "audience", "expired"}},
+	}
+
+	for _, tt := range tests {
+		problems := tt.claims.Problems(tt.resource, tt.identity)
+		if len(problems) != len(tt.want) {
+			t.Errorf("%s: Problems = %q, want %d", tt.name, problems, len(tt.want))
+			continue
+		}
+
+		for i, want := range tt.want {
+			if !strings.Contains(problems[i], want) {
+				t.Errorf("%s: problem %q should mention %q", tt.name, problems[i], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package azcred
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+)
+
+const (
+	// IdentityVar selects the managed identity used for every resource
+	IdentityVar = "AZURE_MSI_IDENTITY"
+
+	// IdentitiesVar maps resources to identities, e.g. keyvault=<client id>;storage=mi_res_id=<resource id>
+	IdentitiesVar = "AZURE_MSI_IDENTITIES"
+
+	// imdsTimeout bounds a single token request to IMDS, a hung request
+	// would otherwise block the container's start forever
+	imdsTimeout = time.Second * 30
+)
+
+// imdsClient sends the token requests to IMDS, adal's default client has no timeout
+var imdsClient = &http.Client{Timeout: imdsTimeout}
+
+// Identity selects a managed identity by one of the IDs IMDS accepts. The
+// zero value is the system assigned identity.
+type Identity struct {
+	ClientID   string
+	ObjectID   string
+	ResourceID string
+}
+
+// ParseIdentity reads an identity written as client_id=<id>, object_id=<id>
+// or mi_res_id=<resource id>. A bare resource ID is taken as mi_res_id, any
+// other bare value as a client ID, and an empty value or "system" as the
+// system assigned identity.
+func ParseIdentity(value string) (Identity, error) {
+	value = strings.TrimSpace(value)
+
+	switch {
+	case value == "" || strings.EqualFold(value, "system"):
+		return Identity{}, nil
+	case strings.HasPrefix(value, "client_id="):
+		return Identity{ClientID: strings.TrimPrefix(value, "client_id=")}, nil
+	case strings.HasPrefix(value, "object_id="):
+		return Identity{ObjectID: strings.TrimPrefix(value, "object_id=")}, nil
+	case strings.HasPrefix(value, "mi_res_id="):
+		return Identity{ResourceID: strings.TrimPrefix(value, "mi_res_id=")}, nil
+	case strings.HasPrefix(strings.ToLower(value), "/subscriptions/"):
+		return Identity{ResourceID: value}, nil
+	case strings.Contains(value, "="):
+		return Identity{}, fmt.Errorf("unknown identity '%s', use client_id=, object_id= or mi_res_id=", value)
+	}
+
+	return Identity{ClientID: value}, nil
+}
+
+// ParseIdentities reads a resource to identity mapping written as
+// resource=identity pairs separated by semicolons. Resources can be
+// keyvault, management, storage or a resource URI.
+func ParseIdentities(env azure.Environment, value string) (map[string]Identity, error) {
+	identities := map[string]Identity{}
+	for _, pair := range strings.Split(value, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		idx := strings.Index(pair, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("expected resource=identity, got '%s'", pair)
+		}
+
+		id, err := ParseIdentity(pair[idx+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		identities[normalizeResource(azenv.Resource(env, pair[:idx]))] = id
+	}
+
+	return identities, nil
+}
+
+// IsSystemAssigned reports whether the identity is the system assigned one
+func (i Identity) IsSystemAssigned() bool {
+	return i == Identity{}
+}
+
+func (i Identity) String() string {
+	switch {
+	case i.ClientID != "":
+		return "client_id=" + i.ClientID
+	case i.ObjectID != "":
+		return "object_id=" + i.ObjectID
+	case i.ResourceID != "":
+		return "mi_res_id=" + i.ResourceID
+	}
+
+	return "system assigned"
+}
+
+// newMSIToken gets tokens from IMDS for the identity. adal only knows how to
+// select an identity by client ID, so object and resource IDs are added to
+// the token request by the sender. adal's own IMDS retries are turned off,
+// the shared retry policy retries token requests along with the request
+// they authorize.
+func newMSIToken(resource string, identity Identity) (tokenSource, error) {
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.ClientID != "" {
+		spt, err := adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, resource, identity.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		spt.SetSender(imdsClient)
+		spt.MaxMSIRefreshAttempts = 1
+		return spt, nil
+	}
+
+	spt, err := adal.NewServicePrincipalTokenFromMSI(msiEndpoint, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case identity.ObjectID != "":
+		spt.SetSender(identitySender{name: "object_id", value: identity.ObjectID})
+	case identity.ResourceID != "":
+		spt.SetSender(identitySender{name: "mi_res_id", value: identity.ResourceID})
+	default:
+		spt.SetSender(imdsClient)
+	}
+	spt.MaxMSIRefreshAttempts = 1
+
+	return spt, nil
+}
+
+// identitySender adds an identity selector to IMDS token requests
+type identitySender struct {
+	name  string
+	value string
+}
+
+func (s identitySender) Do(r *http.Request) (*http.Response, error) {
+	u := *r.URL
+	q := u.Query()
+	q.Set(s.name, s.value)
+	u.RawQuery = q.Encode()
+
+	req := r.WithContext(r.Context())
+	req.URL = &u
+
+	return imdsClient.Do(req)
+}
+
+func normalizeResource(resource string) string {
+	return strings.ToLower(strings.TrimSuffix(resource, "/"))
+}
@@ -0,0 +1,144 @@
+package azcred
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+)
+
+const testResourceID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/app"
+
+func TestParseIdentity(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Identity
+		wantErr bool
+	}{
+		{value: "", want: Identity{}},
+		{value: "system", want: Identity{}},
+		{value: " System ", want: Identity{}},
+		{value: "1234-abcd", want: Identity{ClientID: "1234-abcd"}},
+		{value: "client_id=1234-abcd", want: Identity{ClientID: "1234-abcd"}},
+		{value: "object_id=5678-ef01", want: Identity{ObjectID: "5678-ef01"}},
+		{value: "mi_res_id=" + testResourceID, want: Identity{ResourceID: testResourceID}},
+		{value: testResourceID, want: Identity{ResourceID: testResourceID}},
+		{value: "/Subscriptions/sub/resourceGroups/rg", want: Identity{ResourceID: "/Subscriptions/sub/resourceGroups/rg"}},
+		{value: "principal_id=1234", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseIdentity(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseIdentity(%q) = %+v, want an error", tt.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseIdentity(%q) failed: %v", tt.value, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseIdentity(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseIdentities(t *testing.T) {
+	env := azure.PublicCloud
+
+	tests := []struct {
+		value   string
+		want    map[string]Identity
+		wantErr bool
+	}{
+		{value: "", want: map[string]Identity{}},
+		{
+			value: "keyvault=1234; storage=mi_res_id=" + testResourceID + ";",
+			want: map[string]Identity{
+				normalizeResource(azenv.KeyVaultResource(env)): {ClientID: "1234"},
+				"https://storage.azure.com":                    {ResourceID: testResourceID},
+			},
+		},
+		{
+			value: "management=object_id=5678;https://example.com/=system",
+			want: map[string]Identity{
+				normalizeResource(azenv.ResourceManagerResource(env)): {ObjectID: "5678"},
+				"https://example.com": {},
+			},
+		},
+		{value: "keyvault", wantErr: true},
+		{value: "=1234", wantErr: true},
+		{value: "keyvault=principal_id=1234", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseIdentities(env, tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseIdentities(%q) = %+v, want an error", tt.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseIdentities(%q) failed: %v", tt.value, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseIdentities(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestIdentityString(t *testing.T) {
+	tests := []struct {
+		identity Identity
+		want     string
+	}{
+		{Identity{}, "system assigned"},
+		{Identity{ClientID: "1234"}, "client_id=1234"},
+		{Identity{ObjectID: "5678"}, "object_id=5678"},
+		{Identity{ResourceID: testResourceID}, "mi_res_id=" + testResourceID},
+	}
+
+	for _, tt := range tests {
+		if got := tt.identity.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.identity, got, tt.want)
+		}
+
+		parsed, err := ParseIdentity(tt.identity.String())
+		if tt.identity.IsSystemAssigned() {
+			continue
+		}
+
+		if err != nil || parsed != tt.identity {
+			t.Errorf("ParseIdentity(%q) = %+v, %v, want %+v", tt.identity.String(), parsed, err, tt.identity)
+		}
+	}
+}
+
+func TestIdentityFor(t *testing.T) {
+	chain, err := NewChain(azure.PublicCloud, Identity{ClientID: "default"}, MSI)
+	if err != nil {
+		t.Fatalf("NewChain failed: %v", err)
+	}
+	chain.Identities["https://storage.azure.com"] = Identity{ObjectID: "storage"}
+
+	if got := chain.IdentityFor("https://storage.azure.com/"); got != (Identity{ObjectID: "storage"}) {
+		t.Errorf("IdentityFor(storage) = %+v", got)
+	}
+
+	if got := chain.IdentityFor("https://vault.azure.net"); got != (Identity{ClientID: "default"}) {
+		t.Errorf("IdentityFor(keyvault) = %+v", got)
+	}
+
+	if _, err := NewChain(azure.PublicCloud, Identity{}, "password"); err == nil {
+		t.Error("NewChain should refuse an unknown method")
+	}
+}
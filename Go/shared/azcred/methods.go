@@ -0,0 +1,181 @@
+package azcred
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/azure/cli"
+)
+
+// cliRefreshWithin is how long before expiry a CLI token is fetched again
+const cliRefreshWithin = time.Minute * 5
+
+// errNotConfigured is returned for methods whose environment variables aren't set
+type errNotConfigured []string
+
+func (e errNotConfigured) Error() string {
+	return fmt.Sprintf("not configured, set %v", []string(e))
+}
+
+func newClientSecretToken(env azure.Environment, resource string) (tokenSource, error) {
+	clientID, secret, tenantID := os.Getenv(ClientIDVar), os.Getenv(ClientSecretVar), os.Getenv(TenantIDVar)
+	if clientID == "" || secret == "" || tenantID == "" {
+		return nil, errNotConfigured{ClientIDVar, ClientSecretVar, TenantIDVar}
+	}
+
+	return newAuthorizerToken(auth.ClientCredentialsConfig{
+		ClientID:     clientID,
+		ClientSecret: secret,
+		TenantID:     tenantID,
+		AADEndpoint:  env.ActiveDirectoryEndpoint,
+		Resource:     resource,
+	})
+}
+
+func newClientCertificateToken(env azure.Environment, resource string) (tokenSource, error) {
+	clientID, certPath, tenantID := os.Getenv(ClientIDVar), os.Getenv(CertificatePathVar), os.Getenv(TenantIDVar)
+	if clientID == "" || certPath == "" || tenantID == "" {
+		return nil, errNotConfigured{ClientIDVar, CertificatePathVar, TenantIDVar}
+	}
+
+	return newAuthorizerToken(auth.ClientCertificateConfig{
+		ClientID:            clientID,
+		CertificatePath:     certPath,
+		CertificatePassword: os.Getenv(CertificatePasswordVar),
+		TenantID:            tenantID,
+		AADEndpoint:         env.ActiveDirectoryEndpoint,
+		Resource:            resource,
+	})
+}
+
+// authorizerToken exposes the token of an authorizer from the auth package.
+// The auth package keeps its ServicePrincipalToken to itself, so the token is
+// refreshed and read back by authorizing a request that is never sent.
+type authorizerToken struct {
+	authorizer autorest.Authorizer
+
+	mu    sync.Mutex
+	token string
+}
+
+func newAuthorizerToken(config auth.AuthorizerConfig) (*authorizerToken, error) {
+	authorizer, err := config.Authorizer()
+	if err != nil {
+		return nil, err
+	}
+
+	return &authorizerToken{authorizer: authorizer}, nil
+}
+
+func (t *authorizerToken) OAuthToken() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.token
+}
+
+func (t *authorizerToken) EnsureFreshWithContext(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, "https://localhost", nil)
+	if err != nil {
+		return err
+	}
+
+	req, err = autorest.Prepare(req.WithContext(ctx), t.authorizer.WithAuthorization())
+	if err != nil {
+		// Hand back adal's error so callers still see the token endpoint's response
+		if detailed, ok := err.(autorest.DetailedError); ok && detailed.Original != nil {
+			return detailed.Original
+		}
+		return err
+	}
+
+	t.mu.Lock()
+	t.token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	t.mu.Unlock()
+
+	return nil
+}
+
+// RefreshWithContext can only ensure the token is fresh, the authorizer
+// doesn't offer more. A new authorizerToken starts without a token, so the
+// chain's probe still goes to the token endpoint.
+func (t *authorizerToken) RefreshWithContext(ctx context.Context) error {
+	return t.EnsureFreshWithContext(ctx)
+}
+
+func (t *authorizerToken) RefreshExchangeWithContext(ctx context.Context, resource string) error {
+	return fmt.Errorf("exchanging the token for '%s' is not supported, create a token for the resource", resource)
+}
+
+// cliToken gets tokens from the Azure CLI's logged in account, running
+// az account get-access-token again when the token is about to expire.
+// auth.NewAuthorizerFromCLIWithResource reads the token once and never
+// refreshes it, so long running programs would fail after an hour.
+type cliToken struct {
+	resource string
+
+	mu    sync.Mutex
+	token adal.Token
+}
+
+func newCLIToken(resource string) *cliToken {
+	return &cliToken{resource: resource}
+}
+
+func (t *cliToken) OAuthToken() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.token.AccessToken
+}
+
+func (t *cliToken) EnsureFreshWithContext(ctx context.Context) error {
+	t.mu.Lock()
+	fresh := !t.token.IsZero() && !t.token.WillExpireIn(cliRefreshWithin)
+	t.mu.Unlock()
+
+	if fresh {
+		return nil
+	}
+
+	return t.RefreshWithContext(ctx)
+}
+
+func (t *cliToken) RefreshWithContext(ctx context.Context) error {
+	t.mu.Lock()
+	resource := t.resource
+	t.mu.Unlock()
+
+	token, err := cli.GetTokenFromCLI(resource)
+	if err != nil {
+		return err
+	}
+
+	adalToken, err := token.ToADALToken()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.token = adalToken
+	t.mu.Unlock()
+
+	return nil
+}
+
+func (t *cliToken) RefreshExchangeWithContext(ctx context.Context, resource string) error {
+	t.mu.Lock()
+	t.resource = resource
+	t.mu.Unlock()
+
+	return t.RefreshWithContext(ctx)
+}
@@ -0,0 +1,96 @@
+package azdoctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Status is the outcome of a check
+type Status string
+
+// The outcomes a check can have
+const (
+	Pass Status = "PASS"
+	Fail Status = "FAIL"
+	Skip Status = "SKIP"
+)
+
+// Result is the outcome of a single check with a hint on how to fix it
+type Result struct {
+	Check  string `json:"check"`
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// Report collects the results of the checks run against a deployment
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Add appends results to the report
+func (r *Report) Add(results ...Result) {
+	r.Results = append(r.Results, results...)
+}
+
+// Failed returns the number of checks that failed
+func (r *Report) Failed() int {
+	failed := 0
+	for _, res := range r.Results {
+		if res.Status == Fail {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// Err returns an error when any check failed
+func (r *Report) Err() error {
+	if failed := r.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(r.Results))
+	}
+
+	return nil
+}
+
+// Write prints the report as a table followed by the hints for the failed checks
+func (r *Report) Write(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCHECK\tDETAIL")
+	for _, res := range r.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", res.Status, res.Check, res.Detail)
+	}
+	tw.Flush()
+
+	var hints []string
+	for _, res := range r.Results {
+		if res.Status == Fail && res.Hint != "" {
+			hints = append(hints, fmt.Sprintf("- %s: %s", res.Check, res.Hint))
+		}
+	}
+
+	if len(hints) > 0 {
+		fmt.Fprintf(w, "\nTo fix:\n%s\n", strings.Join(hints, "\n"))
+	}
+}
+
+// WriteFormat prints the report as a text table or as JSON
+func (r *Report) WriteFormat(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		r.Write(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(r)
+	default:
+		return fmt.Errorf("unknown output format '%s'", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package azdoctor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestEnv(t *testing.T) {
+	os.Setenv("AZDOCTOR_TEST_SET", "1")
+	os.Unsetenv("AZDOCTOR_TEST_MISSING_A")
+	os.Unsetenv("AZDOCTOR_TEST_MISSING_B")
+	defer os.Unsetenv("AZDOCTOR_TEST_SET")
+
+	if res := Env("AZDOCTOR_TEST_SET"); res.Status != Pass {
+		t.Errorf("Env with every variable set = %+v", res)
+	}
+
+	res := Env("AZDOCTOR_TEST_MISSING_A", "AZDOCTOR_TEST_SET", "AZDOCTOR_TEST_MISSING_B")
+	if res.Status != Fail || res.Detail != "missing AZDOCTOR_TEST_MISSING_A, AZDOCTOR_TEST_MISSING_B" {
+		t.Errorf("Env should report every missing variable at once, got %+v", res)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	hints := Hints{http.StatusForbidden: "grant access"}
+	forbidden := autorest.DetailedError{Original: errors.New("denied"), Response: &http.Response{StatusCode: http.StatusForbidden}}
+	notFound := autorest.DetailedError{Original: errors.New("missing"), Response: &http.Response{StatusCode: http.StatusNotFound}}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus Status
+		wantDetail string
+		wantHint   string
+	}{
+		{"pass", nil, Pass, "version v1", ""},
+		{"hinted status", forbidden, Fail, "403 Forbidden", "grant access"},
+		{"status without hint", notFound, Fail, "404 Not Found", ""},
+		{"no response", errors.New("dial tcp: no such host\nmore detail"), Fail, "dial tcp: no such host", "the request never got a response, check the DNS and IMDS checks above"},
+	}
+
+	for _, tt := range tests {
+		res := Probe("secret a", tt.err, "version v1", hints)
+		if res.Status != tt.wantStatus || res.Detail != tt.wantDetail || res.Hint != tt.wantHint {
+			t.Errorf("%s: Probe = %+v, want %s %q %q", tt.name, res, tt.wantStatus, tt.wantDetail, tt.wantHint)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := &Report{}
+	report.Add(Result{Check: "environment", Status: Pass})
+	if err := report.Err(); err != nil {
+		t.Errorf("a passing report should not fail: %v", err)
+	}
+
+	report.Add(
+		Result{Check: "imds", Status: Fail, Detail: "timeout", Hint: "enable the identity"},
+		Result{Check: "secrets", Status: Skip},
+	)
+	if report.Failed() != 1 || report.Err() == nil {
+		t.Errorf("Failed = %d, Err = %v, want one failure", report.Failed(), report.Err())
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteFormat(&text, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "- imds: enable the identity") {
+		t.Errorf("the text report should list the hint for the failed check:\n%s", text.String())
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteFormat(&out, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || len(decoded.Results) != 3 {
+		t.Errorf("the JSON report = %s, %v", out.String(), err)
+	}
+
+	if err := report.WriteFormat(&out, "yaml"); err == nil {
+		t.Error("an unknown format should be refused")
+	}
+}
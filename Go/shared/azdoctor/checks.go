@@ -0,0 +1,262 @@
+package azdoctor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/samkreter/container-instance-examples/Go/shared/azcred"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+	"github.com/samkreter/container-instance-examples/Go/shared/azkeyvault"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// checkTimeout bounds the network checks, a deployment that is going to work
+// answers well within it
+const checkTimeout = time.Second * 5
+
+// Hints maps the status code a probe failed with to how to fix it. The hint
+// for zero is used when the request never got a response.
+type Hints map[int]string
+
+// Env checks the required variables are set, reporting every missing one at once
+func Env(names ...string) Result {
+	var missing []string
+	for _, name := range names {
+		if _, ok := os.LookupEnv(name); !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return Result{
+			Check:  "environment",
+			Status: Fail,
+			Detail: "missing " + strings.Join(missing, ", "),
+			Hint:   fmt.Sprintf("set them on the container, e.g. az container create ... -e %s=<value>", missing[0]),
+		}
+	}
+
+	return Result{Check: "environment", Status: Pass, Detail: fmt.Sprintf("%d required variables set", len(names))}
+}
+
+// Credential checks the cloud and credential chain settings and builds the
+// chain the programs would use, nil when the settings are invalid
+func Credential(msiIdentity string) (Result, *azcred.Chain) {
+	env, err := azenv.FromEnvironment()
+	if err != nil {
+		return Result{
+			Check:  "credential",
+			Status: Fail,
+			Detail: firstLine(err),
+			Hint:   "set AZURE_ENVIRONMENT to a cloud name such as AzurePublicCloud or AzureChinaCloud",
+		}, nil
+	}
+
+	chain, err := azcred.FromEnvironment(env, msiIdentity)
+	if err != nil {
+		return Result{
+			Check:  "credential",
+			Status: Fail,
+			Detail: firstLine(err),
+			Hint:   fmt.Sprintf("fix %s, %s or %s", azcred.ChainVar, azcred.IdentityVar, azcred.IdentitiesVar),
+		}, nil
+	}
+
+	detail := fmt.Sprintf("%s, chain %s, identity %s", env.Name, strings.Join(chain.Methods, ","), chain.Identity)
+	return Result{Check: "credential", Status: Pass, Detail: detail}, chain
+}
+
+// IMDS checks the managed identity endpoint answers. It's skipped when the
+// chain doesn't use MSI.
+func IMDS(ctx context.Context, chain *azcred.Chain) Result {
+	res := Result{Check: "imds"}
+	if !usesMSI(chain) {
+		res.Status = Skip
+		res.Detail = "msi is not in the credential chain"
+		return res
+	}
+
+	endpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		res.Status = Fail
+		res.Detail = err.Error()
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		res.Status = Fail
+		res.Detail = err.Error()
+		return res
+	}
+	req.Header.Set("Metadata", "true")
+
+	// Any answer will do, without a resource IMDS rejects the request
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		res.Status = Fail
+		res.Detail = firstLine(err)
+		res.Hint = "the container group has no managed identity endpoint, deploy it with --assign-identity, or set AZURE_CREDENTIAL_CHAIN to leave msi out when running outside Azure"
+		return res
+	}
+	resp.Body.Close()
+
+	res.Status = Pass
+	res.Detail = "reachable at " + req.URL.Host
+	return res
+}
+
+// Token checks a token can be acquired for the resource, one of keyvault,
+// management, storage or a resource URI, and that it was issued to the
+// configured identity. The claims are returned for the hints of the
+// permission checks, nil when no token was acquired.
+func Token(ctx context.Context, chain *azcred.Chain, name string) (Result, *azcred.Claims) {
+	resource := azenv.Resource(chain.Environment, name)
+	res := Result{Check: "token " + name}
+
+	token, method, err := chain.Token(ctx, resource)
+	if err != nil {
+		res.Status = Fail
+		res.Detail = firstLine(err)
+		res.Hint = "assign an identity to the container group with --assign-identity, or configure a service principal or az login, see AZURE_CREDENTIAL_CHAIN"
+		if identity := chain.IdentityFor(resource); !identity.IsSystemAssigned() {
+			res.Hint = fmt.Sprintf("check the identity %s is assigned to the container group with --assign-identity", identity)
+		}
+		return res, nil
+	}
+
+	claims, err := azcred.DecodeClaims(token.OAuthToken())
+	if err != nil {
+		res.Status = Fail
+		res.Detail = err.Error()
+		return res, nil
+	}
+
+	identity := azcred.Identity{}
+	if method == azcred.MSI {
+		identity = chain.IdentityFor(resource)
+	}
+
+	if problems := claims.Problems(resource, identity); len(problems) > 0 {
+		res.Status = Fail
+		res.Detail = strings.Join(problems, "; ")
+		res.Hint = fmt.Sprintf("set %s or %s to the identity that was granted access", azcred.IdentityVar, azcred.IdentitiesVar)
+		return res, claims
+	}
+
+	res.Status = Pass
+	res.Detail = fmt.Sprintf("%s, principal %s", method, Principal(claims))
+	return res, claims
+}
+
+// Tokens runs the token check for each of the resources and returns the
+// claims of the tokens by resource name
+func Tokens(ctx context.Context, chain *azcred.Chain, names ...string) ([]Result, map[string]*azcred.Claims) {
+	results := make([]Result, 0, len(names))
+	claims := map[string]*azcred.Claims{}
+	for _, name := range names {
+		res, c := Token(ctx, chain, name)
+		results = append(results, res)
+		claims[name] = c
+	}
+
+	return results, claims
+}
+
+// DNS checks the host of an endpoint resolves
+func DNS(ctx context.Context, endpoint string) Result {
+	host := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return Result{
+			Check:  "dns " + host,
+			Status: Fail,
+			Detail: firstLine(err),
+			Hint:   "check the name is spelled right and that the container group's network can resolve it",
+		}
+	}
+
+	return Result{Check: "dns " + host, Status: Pass, Detail: strings.Join(addrs, ", ")}
+}
+
+// Probe turns the error of a request made to check access into a result,
+// with the hint for the status code it failed with
+func Probe(check string, err error, detail string, hints Hints) Result {
+	if err == nil {
+		return Result{Check: check, Status: Pass, Detail: detail}
+	}
+
+	code := azretry.StatusCode(err)
+	res := Result{Check: check, Status: Fail, Detail: firstLine(err), Hint: hints[code]}
+	if code != 0 {
+		res.Detail = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	} else if res.Hint == "" {
+		res.Hint = "the request never got a response, check the DNS and IMDS checks above"
+	}
+
+	return res
+}
+
+// SecretAccess checks the identity can read the secret. The value is never printed.
+func SecretAccess(ctx context.Context, getter azkeyvault.SecretGetter, vaultName, ref string, claims *azcred.Claims) Result {
+	secret, err := getter.GetSecret(ctx, ref)
+
+	detail := ""
+	if err == nil {
+		detail = "version " + secret.Version
+	}
+
+	principal := Principal(claims)
+	return Probe("secret "+ref, err, detail, Hints{
+		http.StatusUnauthorized: "keyvault rejected the token, check the identity is in the vault's tenant",
+		http.StatusForbidden:    fmt.Sprintf("grant `get` secret permission to principal %s: az keyvault set-policy --name %s --object-id %s --secret-permissions get", principal, vaultName, principal),
+		http.StatusNotFound:     fmt.Sprintf("create the secret in vault %s or fix its name", vaultName),
+	})
+}
+
+func usesMSI(chain *azcred.Chain) bool {
+	for _, m := range chain.Methods {
+		if m == azcred.MSI {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Principal returns the object ID to grant access to, a placeholder when no
+// token was acquired
+func Principal(claims *azcred.Claims) string {
+	if claims == nil || claims.ObjectID == "" {
+		return "<object id>"
+	}
+
+	return claims.ObjectID
+}
+
+// firstLine keeps table rows readable, azblob errors span many lines
+func firstLine(err error) string {
+	msg := strings.TrimSpace(err.Error())
+	if idx := strings.Index(msg, "\n"); idx >= 0 {
+		return msg[:idx]
+	}
+
+	return msg
+}
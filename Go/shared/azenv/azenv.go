@@ -0,0 +1,93 @@
+package azenv
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+const (
+	// EnvironmentNameVar selects a cloud by name, e.g. AzureChinaCloud or AzureUSGovernmentCloud
+	EnvironmentNameVar = "AZURE_ENVIRONMENT"
+	// EnvironmentFileVar points to a JSON file describing a custom cloud
+	EnvironmentFileVar = azure.EnvironmentFilepathName
+	// MetadataEndpointVar is a resource manager endpoint to load the cloud's metadata from, e.g. on Azure Stack
+	MetadataEndpointVar = "AZURE_METADATA_ENDPOINT"
+)
+
+// FromEnvironment selects the cloud from the environment variables. The
+// metadata endpoint takes precedence over the file, which takes precedence
+// over the name. The public cloud is used when none are set.
+func FromEnvironment() (azure.Environment, error) {
+	return Load(os.Getenv(EnvironmentNameVar), os.Getenv(EnvironmentFileVar), os.Getenv(MetadataEndpointVar))
+}
+
+// Load selects a cloud by metadata endpoint, file or name, in that order
+func Load(name, filePath, metadataEndpoint string) (azure.Environment, error) {
+	switch {
+	case metadataEndpoint != "":
+		env, err := azure.EnvironmentFromURL(metadataEndpoint)
+		if err != nil {
+			return env, fmt.Errorf("failed to load cloud metadata from '%s': %v", metadataEndpoint, err)
+		}
+		return env, nil
+
+	case filePath != "" && (name == "" || strings.EqualFold(name, "AzureStackCloud")):
+		env, err := azure.EnvironmentFromFile(filePath)
+		if err != nil {
+			return env, fmt.Errorf("failed to load cloud environment from '%s': %v", filePath, err)
+		}
+		return env, nil
+
+	case name != "":
+		return azure.EnvironmentFromName(name)
+	}
+
+	return azure.PublicCloud, nil
+}
+
+// KeyVaultResource returns the token audience for keyvault in the cloud
+func KeyVaultResource(env azure.Environment) string {
+	return strings.TrimSuffix(env.KeyVaultEndpoint, "/")
+}
+
+// ResourceManagerResource returns the token audience for resource manager in the cloud
+func ResourceManagerResource(env azure.Environment) string {
+	if env.TokenAudience != "" {
+		return env.TokenAudience
+	}
+
+	return env.ResourceManagerEndpoint
+}
+
+// StorageResource returns the token audience for storage, it's the same in every cloud
+func StorageResource(env azure.Environment) string {
+	return "https://storage.azure.com/"
+}
+
+// Resource maps the short names keyvault, management and storage to their
+// token audience in the cloud. Anything else is returned as is.
+func Resource(env azure.Environment, name string) string {
+	switch strings.ToLower(name) {
+	case "keyvault":
+		return KeyVaultResource(env)
+	case "management", "arm":
+		return ResourceManagerResource(env)
+	case "storage":
+		return StorageResource(env)
+	}
+
+	return name
+}
+
+// VaultURL returns the URL of the named vault in the cloud
+func VaultURL(env azure.Environment, vaultName string) string {
+	return fmt.Sprintf("https://%s.%s", vaultName, env.KeyVaultDNSSuffix)
+}
+
+// BlobEndpoint returns the blob service URL of the storage account in the cloud
+func BlobEndpoint(env azure.Environment, accountName string) string {
+	return fmt.Sprintf("https://%s.blob.%s", accountName, env.StorageEndpointSuffix)
+}
@@ -0,0 +1,101 @@
+package azenv
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "azenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"name": "AzureStackCloud", "keyVaultDNSSuffix": "vault.local.azurestack.external", "storageEndpointSuffix": "local.azurestack.external"}`)
+	f.Close()
+
+	tests := []struct {
+		name     string
+		filePath string
+		want     string
+		wantErr  bool
+	}{
+		{want: azure.PublicCloud.Name},
+		{name: "AzureChinaCloud", want: azure.ChinaCloud.Name},
+		{name: "azureusgovernmentcloud", want: azure.USGovernmentCloud.Name},
+		{filePath: f.Name(), want: "AzureStackCloud"},
+		{name: "AzureStackCloud", filePath: f.Name(), want: "AzureStackCloud"},
+		{name: "AzureChinaCloud", filePath: f.Name(), want: azure.ChinaCloud.Name},
+		{name: "NoSuchCloud", wantErr: true},
+		{filePath: f.Name() + ".missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		env, err := Load(tt.name, tt.filePath, "")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Load(%q, %q) = %s, want an error", tt.name, tt.filePath, env.Name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Load(%q, %q) failed: %v", tt.name, tt.filePath, err)
+			continue
+		}
+
+		if env.Name != tt.want {
+			t.Errorf("Load(%q, %q) = %s, want %s", tt.name, tt.filePath, env.Name, tt.want)
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	env := azure.ChinaCloud
+
+	if got, want := VaultURL(env, "myvault"), "https://myvault.vault.azure.cn"; got != want {
+		t.Errorf("VaultURL = %q, want %q", got, want)
+	}
+
+	if got, want := BlobEndpoint(env, "myaccount"), "https://myaccount.blob.core.chinacloudapi.cn"; got != want {
+		t.Errorf("BlobEndpoint = %q, want %q", got, want)
+	}
+
+	if got, want := KeyVaultResource(env), "https://vault.azure.cn"; got != want {
+		t.Errorf("KeyVaultResource = %q, want %q", got, want)
+	}
+
+	if got, want := ResourceManagerResource(env), env.TokenAudience; got != want {
+		t.Errorf("ResourceManagerResource = %q, want %q", got, want)
+	}
+
+	env.TokenAudience = ""
+	if got, want := ResourceManagerResource(env), env.ResourceManagerEndpoint; got != want {
+		t.Errorf("ResourceManagerResource without an audience = %q, want %q", got, want)
+	}
+}
+
+func TestResource(t *testing.T) {
+	env := azure.ChinaCloud
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"keyvault", "https://vault.azure.cn"},
+		{"Management", env.TokenAudience},
+		{"arm", env.TokenAudience},
+		{"storage", "https://storage.azure.com/"},
+		{"https://ossrdbms-aad.database.chinacloudapi.cn", "https://ossrdbms-aad.database.chinacloudapi.cn"},
+	}
+
+	for _, tt := range tests {
+		if got := Resource(env, tt.name); got != tt.want {
+			t.Errorf("Resource(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
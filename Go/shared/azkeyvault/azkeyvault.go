@@ -0,0 +1,197 @@
+package azkeyvault
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/samkreter/container-instance-examples/Go/shared/azcred"
+	"github.com/samkreter/container-instance-examples/Go/shared/azenv"
+	"github.com/samkreter/container-instance-examples/Go/shared/azretry"
+)
+
+// requestTimeout bounds each try of a request, a hung connection would otherwise block the caller forever
+const requestTimeout = time.Second * 30
+
+// KeyVault holds the information for a keyvault instance
+type KeyVault struct {
+	client    *keyvault.BaseClient
+	vaultURL  string
+	dnsSuffix string
+}
+
+// SecretProperties holds the metadata of a single secret version
+type SecretProperties struct {
+	ID          string
+	Name        string
+	Version     string
+	ContentType string
+	Tags        map[string]string
+	Enabled     bool
+	NotBefore   *time.Time
+	Expires     *time.Time
+	Created     *time.Time
+	Updated     *time.Time
+}
+
+// Secret holds a secret value along with its metadata
+type Secret struct {
+	SecretProperties
+	Value string
+}
+
+// NewKeyVaultClient creates a new keyvault client for the public cloud. The
+// vault name may be empty when every secret is referenced by its full identifier.
+func NewKeyVaultClient(vaultName, msiIdentity string) (*KeyVault, error) {
+	return NewKeyVaultClientWithEnvironment(azure.PublicCloud, vaultName, msiIdentity)
+}
+
+// NewKeyVaultClientWithEnvironment creates a new keyvault client for the given
+// cloud. It authenticates with the credential chain configured in the
+// environment. The MSI identity is a user assigned identity's client ID,
+// object_id=<id> or mi_res_id=<resource id>, empty for the system assigned one.
+func NewKeyVaultClientWithEnvironment(env azure.Environment, vaultName, msiIdentity string) (*KeyVault, error) {
+	chain, err := azcred.FromEnvironment(env, msiIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyVaultClientWithCredential(env, vaultName, chain)
+}
+
+// NewKeyVaultClientWithCredential creates a new keyvault client authenticating
+// with the chain, retrying with the policy configured in the environment
+func NewKeyVaultClientWithCredential(env azure.Environment, vaultName string, chain *azcred.Chain) (*KeyVault, error) {
+	policy, err := azretry.FromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyVaultClientWithPolicy(env, vaultName, chain, policy), nil
+}
+
+// NewKeyVaultClientWithPolicy creates a new keyvault client authenticating
+// with the chain and retrying with the policy, e.g. azretry.NoRetries
+func NewKeyVaultClientWithPolicy(env azure.Environment, vaultName string, chain *azcred.Chain, policy azretry.Policy) *KeyVault {
+	keyClient := keyvault.New()
+	if client, ok := keyClient.Sender.(*http.Client); ok {
+		client.Timeout = requestTimeout
+	}
+
+	// The shared policy does the retrying, token requests included, so turn off the client's own 5xx retries
+	authorizer := chain.Authorizer(azenv.KeyVaultResource(env))
+	keyClient.Sender = autorest.DecorateSender(keyClient.Sender, azretry.AuthorizeSender(authorizer), policy.SendDecorator())
+	keyClient.RetryAttempts = 0
+
+	k := &KeyVault{
+		client:    &keyClient,
+		dnsSuffix: env.KeyVaultDNSSuffix,
+	}
+
+	if vaultName != "" {
+		k.vaultURL = azenv.VaultURL(env, vaultName)
+	}
+
+	return k
+}
+
+// VaultURL returns the base URL of the vault the client talks to
+func (k *KeyVault) VaultURL() string {
+	return k.vaultURL
+}
+
+// GetSecret retrieves a secret from keyvault. The reference can be a bare
+// secret name, a name pinned to a version as name@version, or a full secret
+// identifier URL.
+func (k *KeyVault) GetSecret(ctx context.Context, secretRef string) (*Secret, error) {
+	ref, err := k.ParseSecretRef(secretRef)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := k.client.GetSecret(ctx, ref.VaultURL, ref.Name, ref.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &Secret{
+		SecretProperties: newSecretProperties(bundle.ID, bundle.ContentType, bundle.Tags, bundle.Attributes),
+		Value:            to.String(bundle.Value),
+	}
+
+	return secret, nil
+}
+
+// ListSecretVersions lists every version of a secret, newest first. The
+// values are not included, use GetSecret with a pinned version to read one.
+func (k *KeyVault) ListSecretVersions(ctx context.Context, secretName string) ([]SecretProperties, error) {
+	ref, err := k.ParseSecretRef(secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := k.client.GetSecretVersionsComplete(ctx, ref.VaultURL, ref.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []SecretProperties
+	for iter.NotDone() {
+		item := iter.Value()
+		versions = append(versions, newSecretProperties(item.ID, item.ContentType, item.Tags, item.Attributes))
+
+		if err := iter.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Created == nil || versions[j].Created == nil {
+			return versions[j].Created == nil && versions[i].Created != nil
+		}
+		return versions[i].Created.After(*versions[j].Created)
+	})
+
+	return versions, nil
+}
+
+func newSecretProperties(id, contentType *string, tags map[string]*string, attributes *keyvault.SecretAttributes) SecretProperties {
+	props := SecretProperties{
+		ID:          to.String(id),
+		ContentType: to.String(contentType),
+		Tags:        to.StringMap(tags),
+		Enabled:     true,
+	}
+
+	if ref, err := parseSecretID(props.ID); err == nil {
+		props.Name = ref.Name
+		props.Version = ref.Version
+	}
+
+	if attributes != nil {
+		if attributes.Enabled != nil {
+			props.Enabled = *attributes.Enabled
+		}
+		props.NotBefore = unixTimePtr(attributes.NotBefore)
+		props.Expires = unixTimePtr(attributes.Expires)
+		props.Created = unixTimePtr(attributes.Created)
+		props.Updated = unixTimePtr(attributes.Updated)
+	}
+
+	return props
+}
+
+func unixTimePtr(t *date.UnixTime) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	tt := time.Time(*t).UTC()
+	return &tt
+}
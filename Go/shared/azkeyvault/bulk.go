@@ -0,0 +1,117 @@
+package azkeyvault
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultConcurrency is the number of secrets fetched in parallel by GetSecrets
+const DefaultConcurrency = 8
+
+// SecretFilter selects secrets when listing a vault. Every tag has to match.
+type SecretFilter struct {
+	Prefix          string
+	Tags            map[string]string
+	IncludeDisabled bool
+}
+
+// Match reports whether the secret passes the filter
+func (f SecretFilter) Match(props SecretProperties) bool {
+	if !strings.HasPrefix(props.Name, f.Prefix) {
+		return false
+	}
+
+	if !props.Enabled && !f.IncludeDisabled {
+		return false
+	}
+
+	for key, value := range f.Tags {
+		if v, ok := props.Tags[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SecretResult holds the outcome of fetching a single secret in a bulk request
+type SecretResult struct {
+	Name   string
+	Secret *Secret
+	Err    error
+}
+
+// ListSecrets lists the current version of every secret in the vault that
+// passes the filter. The values are not included.
+func (k *KeyVault) ListSecrets(ctx context.Context, filter SecretFilter) ([]SecretProperties, error) {
+	if k.vaultURL == "" {
+		return nil, fmt.Errorf("listing secrets requires a vault name")
+	}
+
+	iter, err := k.client.GetSecretsComplete(ctx, k.vaultURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []SecretProperties
+	for iter.NotDone() {
+		item := iter.Value()
+		props := newSecretProperties(item.ID, item.ContentType, item.Tags, item.Attributes)
+		if filter.Match(props) {
+			secrets = append(secrets, props)
+		}
+
+		if err := iter.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	return secrets, nil
+}
+
+// GetSecrets fetches the values of every secret that passes the filter, at
+// most concurrency at a time. Failures are reported per secret in the results,
+// the error is only set when the vault couldn't be listed.
+func (k *KeyVault) GetSecrets(ctx context.Context, filter SecretFilter, concurrency int) ([]SecretResult, error) {
+	secrets, err := k.ListSecrets(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(secrets))
+	for _, s := range secrets {
+		names = append(names, s.Name)
+	}
+
+	return GetSecretsByName(ctx, k, names, concurrency), nil
+}
+
+// GetSecretsByName fetches the secrets in parallel, at most concurrency at a
+// time, and returns a result for each in the order they were given.
+func GetSecretsByName(ctx context.Context, getter SecretGetter, secretRefs []string, concurrency int) []SecretResult {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	results := make([]SecretResult, len(secretRefs))
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for i, ref := range secretRefs {
+		wg.Add(1)
+		go func(i int, ref string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			secret, err := getter.GetSecret(ctx, ref)
+			results[i] = SecretResult{Name: ref, Secret: secret, Err: err}
+		}(i, ref)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,108 @@
+package azkeyvault
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSecretFilterMatch(t *testing.T) {
+	props := SecretProperties{
+		Name:    "orders-db-password",
+		Enabled: true,
+		Tags:    map[string]string{"app": "orders", "env": "prod"},
+	}
+	disabled := props
+	disabled.Enabled = false
+
+	tests := []struct {
+		filter SecretFilter
+		props  SecretProperties
+		want   bool
+	}{
+		{SecretFilter{}, props, true},
+		{SecretFilter{Prefix: "orders-"}, props, true},
+		{SecretFilter{Prefix: "billing-"}, props, false},
+		{SecretFilter{Tags: map[string]string{"app": "orders"}}, props, true},
+		{SecretFilter{Tags: map[string]string{"app": "orders", "env": "prod"}}, props, true},
+		{SecretFilter{Tags: map[string]string{"app": "orders", "env": "dev"}}, props, false},
+		{SecretFilter{Tags: map[string]string{"team": ""}}, props, false},
+		{SecretFilter{Prefix: "orders-", Tags: map[string]string{"app": "billing"}}, props, false},
+		{SecretFilter{}, disabled, false},
+		{SecretFilter{IncludeDisabled: true}, disabled, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Match(tt.props); got != tt.want {
+			t.Errorf("%+v.Match(%s enabled=%v) = %v, want %v", tt.filter, tt.props.Name, tt.props.Enabled, got, tt.want)
+		}
+	}
+}
+
+func TestGetSecretsByName(t *testing.T) {
+	const concurrency = 3
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+
+	getter := secretGetterFunc(func(ctx context.Context, secretRef string) (*Secret, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond * 5)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		if secretRef == "missing" {
+			return nil, fmt.Errorf("secret '%s' not found", secretRef)
+		}
+		return testSecret(secretRef, "v1"), nil
+	})
+
+	refs := []string{"a", "b", "missing", "c", "d", "e", "f", "g"}
+	results := GetSecretsByName(context.Background(), getter, refs, concurrency)
+
+	if len(results) != len(refs) {
+		t.Fatalf("got %d results, want %d", len(results), len(refs))
+	}
+
+	for i, r := range results {
+		if r.Name != refs[i] {
+			t.Errorf("result %d is for '%s', want '%s'", i, r.Name, refs[i])
+		}
+
+		if r.Name == "missing" {
+			if r.Err == nil || r.Secret != nil {
+				t.Errorf("the missing secret should fail on its own, got %+v", r)
+			}
+			continue
+		}
+
+		if r.Err != nil || r.Secret == nil || r.Secret.Name != r.Name {
+			t.Errorf("result for '%s' = %+v", r.Name, r)
+		}
+	}
+
+	if maxRunning > concurrency {
+		t.Errorf("%d fetches ran at once, want at most %d", maxRunning, concurrency)
+	}
+}
+
+func TestGetSecretsByNameDefaultConcurrency(t *testing.T) {
+	getter := secretGetterFunc(func(ctx context.Context, secretRef string) (*Secret, error) {
+		return testSecret(secretRef, "v1"), nil
+	})
+
+	results := GetSecretsByName(context.Background(), getter, []string{"a", "b"}, 0)
+	if len(results) != 2 || results[0].Secret == nil || results[1].Secret == nil {
+		t.Errorf("GetSecretsByName with no concurrency limit = %+v", results)
+	}
+}
@@ -0,0 +1,237 @@
+package azkeyvault
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long a cached secret is served before it's refreshed
+const DefaultCacheTTL = time.Minute * 5
+
+// SecretGetter fetches secrets, it's implemented by both KeyVault and SecretCache
+type SecretGetter interface {
+	GetSecret(ctx context.Context, secretRef string) (*Secret, error)
+}
+
+// SecretCache keeps secrets in memory in front of a keyvault client. Expired
+// secrets are served stale while they're refreshed in the background, and
+// when keyvault errors the last known value keeps being served.
+type SecretCache struct {
+	keyVault *KeyVault
+	ttl      time.Duration
+	snapshot *Snapshot
+
+	mu       sync.Mutex
+	entries  map[string]*cacheEntry
+	ttls     map[string]time.Duration
+	fallback map[string]*Secret
+
+	// snapshotGen numbers the copies of fallback handed to the snapshot
+	snapshotGen uint64
+}
+
+type cacheEntry struct {
+	secret     *Secret
+	fetched    time.Time
+	refreshing bool
+}
+
+// NewSecretCache creates a cache with the default TTL for every secret
+func (k *KeyVault) NewSecretCache(ttl time.Duration) *SecretCache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	return &SecretCache{
+		keyVault: k,
+		ttl:      ttl,
+		entries:  map[string]*cacheEntry{},
+		ttls:     map[string]time.Duration{},
+		fallback: map[string]*Secret{},
+	}
+}
+
+// SetTTL overrides the TTL for a single secret reference
+func (c *SecretCache) SetTTL(secretRef string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttls[secretRef] = ttl
+}
+
+// UseSnapshot loads the last known good secrets from the snapshot and saves
+// every freshly fetched secret to it. Snapshot secrets are only served when
+// keyvault can't be reached.
+func (c *SecretCache) UseSnapshot(snapshot *Snapshot) error {
+	secrets, err := snapshot.Load()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.snapshot = snapshot
+	for ref, secret := range secrets {
+		if _, ok := c.fallback[ref]; !ok {
+			c.fallback[ref] = secret
+		}
+	}
+
+	return nil
+}
+
+// GetSecret returns the cached secret, fetching it on a miss. An expired
+// secret is returned as is while a background refresh fetches the new value.
+// A miss is only served from the snapshot once the client's retries run out.
+func (c *SecretCache) GetSecret(ctx context.Context, secretRef string) (*Secret, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[secretRef]
+	if ok {
+		secret := entry.secret
+		if time.Since(entry.fetched) >= c.ttlFor(secretRef) && !entry.refreshing {
+			entry.refreshing = true
+			go c.refresh(secretRef)
+		}
+		c.mu.Unlock()
+		return secret, nil
+	}
+	c.mu.Unlock()
+
+	return c.fetch(ctx, secretRef)
+}
+
+// Refresh fetches every cached secret that has expired. It's meant to be
+// called on an interval so secrets stay warm without waiting for a read.
+func (c *SecretCache) Refresh() {
+	c.mu.Lock()
+	var expired []string
+	for ref, entry := range c.entries {
+		if time.Since(entry.fetched) >= c.ttlFor(ref) && !entry.refreshing {
+			entry.refreshing = true
+			expired = append(expired, ref)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, ref := range expired {
+		c.refresh(ref)
+	}
+}
+
+// Run refreshes expired secrets on the interval until the context is done
+func (c *SecretCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Refresh()
+		}
+	}
+}
+
+// NewSecretWatcher creates a watcher that polls keyvault itself, so a rotated
+// secret is seen on the next poll instead of once the cached version expires.
+// Every poll refreshes the cache, and a failed poll is answered from the
+// snapshot.
+func (c *SecretCache) NewSecretWatcher(secretRef string, interval time.Duration) *SecretWatcher {
+	return newSecretWatcher(secretGetterFunc(c.fetch), secretRef, interval)
+}
+
+// fetch gets the secret from keyvault and caches it, falling back to the snapshot
+func (c *SecretCache) fetch(ctx context.Context, secretRef string) (*Secret, error) {
+	secret, err := c.keyVault.GetSecret(ctx, secretRef)
+	if err != nil {
+		return c.serveFallback(secretRef, err)
+	}
+
+	c.store(secretRef, secret)
+	return secret, nil
+}
+
+// The client's retry policy bounds a refresh
+func (c *SecretCache) refresh(secretRef string) {
+	secret, err := c.keyVault.GetSecret(context.Background(), secretRef)
+	if err != nil {
+		log.Printf("Failed to refresh secret '%s', serving the cached version: %v", secretRef, err)
+
+		c.mu.Lock()
+		if entry, ok := c.entries[secretRef]; ok {
+			entry.refreshing = false
+		}
+		c.mu.Unlock()
+		return
+	}
+
+	c.store(secretRef, secret)
+}
+
+// serveFallback returns the snapshot value for a secret keyvault failed to return
+func (c *SecretCache) serveFallback(secretRef string, fetchErr error) (*Secret, error) {
+	c.mu.Lock()
+	secret, ok := c.fallback[secretRef]
+	if ok {
+		// Cache the fallback as expired so the next read retries keyvault in the background
+		c.entries[secretRef] = &cacheEntry{secret: secret}
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, fetchErr
+	}
+
+	log.Printf("Failed to get secret '%s', serving version '%s' from the snapshot: %v", secretRef, secret.Version, fetchErr)
+	return secret, nil
+}
+
+func (c *SecretCache) store(secretRef string, secret *Secret) {
+	c.mu.Lock()
+	c.entries[secretRef] = &cacheEntry{
+		secret:  secret,
+		fetched: time.Now(),
+	}
+	previous := c.fallback[secretRef]
+	c.fallback[secretRef] = secret
+
+	// Only rewrite the snapshot when a new version shows up
+	snapshot := c.snapshot
+	var secrets map[string]*Secret
+	var generation uint64
+	if snapshot != nil && (previous == nil || previous.Version != secret.Version) {
+		secrets = make(map[string]*Secret, len(c.fallback))
+		for ref, s := range c.fallback {
+			secrets[ref] = s
+		}
+		c.snapshotGen++
+		generation = c.snapshotGen
+	}
+	c.mu.Unlock()
+
+	if secrets != nil {
+		if err := snapshot.saveGeneration(generation, secrets); err != nil {
+			log.Printf("Failed to save secret snapshot: %v", err)
+		}
+	}
+}
+
+// secretGetterFunc adapts a function to SecretGetter
+type secretGetterFunc func(ctx context.Context, secretRef string) (*Secret, error)
+
+func (f secretGetterFunc) GetSecret(ctx context.Context, secretRef string) (*Secret, error) {
+	return f(ctx, secretRef)
+}
+
+// ttlFor returns the TTL of a secret, the caller must hold the lock
+func (c *SecretCache) ttlFor(secretRef string) time.Duration {
+	if ttl, ok := c.ttls[secretRef]; ok {
+		return ttl
+	}
+
+	return c.ttl
+}
@@ -0,0 +1,140 @@
+package azkeyvault
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+// fakeVault serves a single version of every secret and counts the requests
+type fakeVault struct {
+	mu       sync.Mutex
+	version  string
+	failing  bool
+	requests int
+}
+
+func (f *fakeVault) setVersion(version string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version = version
+}
+
+func (f *fakeVault) setFailing(failing bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing = failing
+}
+
+func (f *fakeVault) Do(r *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+
+	resp := &http.Response{Request: r, Header: http.Header{}, StatusCode: http.StatusOK}
+	if f.failing {
+		resp.StatusCode = http.StatusBadRequest
+		resp.Body = ioutil.NopCloser(strings.NewReader(`{"error":{"code":"BadParameter"}}`))
+		return resp, nil
+	}
+
+	body := fmt.Sprintf(`{"value":"value-%s","id":"https://myvault.vault.azure.net%s/%s"}`, f.version, strings.TrimSuffix(r.URL.Path, "/"), f.version)
+	resp.Body = ioutil.NopCloser(strings.NewReader(body))
+	return resp, nil
+}
+
+func newFakeKeyVault(f *fakeVault) *KeyVault {
+	client := keyvault.New()
+	client.Sender = f
+	client.RetryAttempts = 0
+
+	k := newTestKeyVault()
+	k.client = &client
+	return k
+}
+
+func TestSecretCacheServesCachedSecret(t *testing.T) {
+	f := &fakeVault{version: "v1"}
+	cache := newFakeKeyVault(f).NewSecretCache(time.Hour)
+
+	for i := 0; i < 2; i++ {
+		secret, err := cache.GetSecret(context.Background(), "db-password")
+		if err != nil {
+			t.Fatalf("GetSecret failed: %v", err)
+		}
+		if secret.Version != "v1" || secret.Value != "value-v1" {
+			t.Errorf("GetSecret = %+v", secret)
+		}
+	}
+
+	if f.requests != 1 {
+		t.Errorf("keyvault got %d requests, want 1", f.requests)
+	}
+}
+
+func TestSecretCacheWatcherSeesRotation(t *testing.T) {
+	f := &fakeVault{version: "v1"}
+	cache := newFakeKeyVault(f).NewSecretCache(time.Hour)
+
+	if _, err := cache.GetSecret(context.Background(), "db-password"); err != nil {
+		t.Fatalf("GetSecret failed: %v", err)
+	}
+
+	watcher := cache.NewSecretWatcher("db-password", time.Minute)
+	if _, err := watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+
+	// Rotated well before the cached version expires
+	f.setVersion("v2")
+
+	changed, err := watcher.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+
+	if !changed || watcher.Latest().Version != "v2" {
+		t.Errorf("the watcher should see the rotated version, got changed %v and %+v", changed, watcher.Latest())
+	}
+
+	secret, err := cache.GetSecret(context.Background(), "db-password")
+	if err != nil {
+		t.Fatalf("GetSecret failed: %v", err)
+	}
+
+	if secret.Version != "v2" {
+		t.Errorf("the watcher's poll should refresh the cache, got version %s", secret.Version)
+	}
+}
+
+func TestSecretCacheFallsBackOnFailure(t *testing.T) {
+	f := &fakeVault{version: "v1"}
+	cache := newFakeKeyVault(f).NewSecretCache(time.Hour)
+
+	watcher := cache.NewSecretWatcher("db-password", time.Minute)
+	if _, err := watcher.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+
+	f.setFailing(true)
+
+	changed, err := watcher.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll should be answered with the last known version: %v", err)
+	}
+
+	if changed || watcher.Latest().Version != "v1" {
+		t.Errorf("the watcher should keep the last known version, got changed %v and %+v", changed, watcher.Latest())
+	}
+
+	if _, err := cache.GetSecret(context.Background(), "other"); err == nil {
+		t.Error("a secret that was never fetched has nothing to fall back to")
+	}
+}
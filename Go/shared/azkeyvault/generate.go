@@ -0,0 +1,114 @@
+package azkeyvault
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// AlphabetAlphanumeric holds upper and lower case letters and digits
+	AlphabetAlphanumeric = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+	// AlphabetSymbols adds symbols that are safe to use unquoted in connection strings
+	AlphabetSymbols = AlphabetAlphanumeric + "-_.~!*"
+
+	// PEMContentType marks secrets holding PEM encoded keys or certificates
+	PEMContentType = "application/x-pem-file"
+
+	minPasswordLength = 8
+)
+
+// curves maps the supported curve names to their implementation
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// GeneratePassword returns a random password of the given length drawn
+// uniformly from the alphabet using crypto/rand
+func GeneratePassword(length int, alphabet string) (string, error) {
+	if length < minPasswordLength {
+		return "", fmt.Errorf("password length must be at least %d", minPasswordLength)
+	}
+
+	if alphabet == "" {
+		alphabet = AlphabetAlphanumeric
+	}
+
+	chars := []rune(alphabet)
+	if len(chars) < 2 {
+		return "", fmt.Errorf("alphabet must contain at least 2 characters")
+	}
+
+	max := big.NewInt(int64(len(chars)))
+	password := make([]rune, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = chars[n.Int64()]
+	}
+
+	return string(password), nil
+}
+
+// GenerateRSAKeyPair returns a new RSA private key and its public key as PEM
+func GenerateRSAKeyPair(bits int) (string, error) {
+	if bits < 2048 {
+		return "", fmt.Errorf("RSA keys must be at least 2048 bits")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeKeyPair(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}, key.Public())
+}
+
+// GenerateECKeyPair returns a new EC private key on the named curve and its public key as PEM
+func GenerateECKeyPair(curveName string) (string, error) {
+	curve, ok := curves[curveName]
+	if !ok {
+		return "", fmt.Errorf("unsupported curve '%s', use P-256, P-384 or P-521", curveName)
+	}
+
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeKeyPair(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, key.Public())
+}
+
+func encodeKeyPair(private *pem.Block, public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, private); err != nil {
+		return "", err
+	}
+	if err := pem.Encode(&buf, &pem.Block{Type: "PUBLIC KEY", Bytes: der}); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,118 @@
+package azkeyvault
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	password, err := GeneratePassword(64, "ab")
+	if err != nil {
+		t.Fatalf("GeneratePassword failed: %v", err)
+	}
+
+	if len(password) != 64 || strings.Trim(password, "ab") != "" {
+		t.Errorf("GeneratePassword(64, ab) = %q", password)
+	}
+
+	// Multi byte characters count once towards the length
+	password, err = GeneratePassword(10, "äöü")
+	if err != nil {
+		t.Fatalf("GeneratePassword failed: %v", err)
+	}
+	if n := len([]rune(password)); n != 10 {
+		t.Errorf("GeneratePassword(10, äöü) has %d characters", n)
+	}
+
+	password, err = GeneratePassword(32, "")
+	if err != nil {
+		t.Fatalf("GeneratePassword failed: %v", err)
+	}
+	if strings.Trim(password, AlphabetAlphanumeric) != "" {
+		t.Errorf("the default alphabet should be alphanumeric, got %q", password)
+	}
+
+	other, err := GeneratePassword(32, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password == other {
+		t.Error("two generated passwords should not be equal")
+	}
+
+	for _, tt := range []struct {
+		length   int
+		alphabet string
+	}{
+		{minPasswordLength - 1, ""},
+		{32, "a"},
+	} {
+		if _, err := GeneratePassword(tt.length, tt.alphabet); err == nil {
+			t.Errorf("GeneratePassword(%d, %q) should fail", tt.length, tt.alphabet)
+		}
+	}
+}
+
+func TestGenerateKeyPairs(t *testing.T) {
+	rsaPEM, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("GenerateRSAKeyPair failed: %v", err)
+	}
+
+	private, public := decodeKeyPair(t, rsaPEM)
+	key, err := x509.ParsePKCS1PrivateKey(private.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse RSA key: %v", err)
+	}
+	if pub, ok := public.(*rsa.PublicKey); !ok || pub.N.Cmp(key.N) != 0 {
+		t.Error("the public key does not belong to the RSA private key")
+	}
+
+	ecPEM, err := GenerateECKeyPair("P-384")
+	if err != nil {
+		t.Fatalf("GenerateECKeyPair failed: %v", err)
+	}
+
+	private, public = decodeKeyPair(t, ecPEM)
+	ecKey, err := x509.ParseECPrivateKey(private.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse EC key: %v", err)
+	}
+	if ecKey.Curve.Params().Name != "P-384" {
+		t.Errorf("curve = %s, want P-384", ecKey.Curve.Params().Name)
+	}
+	if pub, ok := public.(*ecdsa.PublicKey); !ok || pub.X.Cmp(ecKey.X) != 0 {
+		t.Error("the public key does not belong to the EC private key")
+	}
+
+	if _, err := GenerateRSAKeyPair(1024); err == nil {
+		t.Error("1024 bit RSA keys should be refused")
+	}
+	if _, err := GenerateECKeyPair("P-224"); err == nil {
+		t.Error("unsupported curves should be refused")
+	}
+}
+
+// decodeKeyPair splits the PEM into the private key block and the parsed public key
+func decodeKeyPair(t *testing.T, data string) (*pem.Block, interface{}) {
+	private, rest := pem.Decode([]byte(data))
+	if private == nil {
+		t.Fatalf("no private key in %q", data)
+	}
+
+	block, _ := pem.Decode(rest)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("no public key in %q", data)
+	}
+
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("failed to parse public key: %v", err)
+	}
+
+	return private, public
+}
@@ -0,0 +1,195 @@
+package azkeyvault
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	// Register the hash implementations used for signing digests
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// KeyOperationResult holds the output of a key operation and the exact key version that produced it
+type KeyOperationResult struct {
+	KeyID  string
+	Result []byte
+}
+
+// signatureHashes maps the supported signature algorithms to the digest they sign
+var signatureHashes = map[keyvault.JSONWebKeySignatureAlgorithm]crypto.Hash{
+	keyvault.RS256:    crypto.SHA256,
+	keyvault.RS384:    crypto.SHA384,
+	keyvault.RS512:    crypto.SHA512,
+	keyvault.PS256:    crypto.SHA256,
+	keyvault.PS384:    crypto.SHA384,
+	keyvault.PS512:    crypto.SHA512,
+	keyvault.ES256:    crypto.SHA256,
+	keyvault.ES384:    crypto.SHA384,
+	keyvault.ES512:    crypto.SHA512,
+	keyvault.ECDSA256: crypto.SHA256,
+}
+
+// Sign signs a digest with the key. The private key never leaves the vault.
+func (k *KeyVault) Sign(ctx context.Context, keyRef, algorithm string, digest []byte) (*KeyOperationResult, error) {
+	ref, err := k.ParseKeyRef(keyRef)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := keyvault.JSONWebKeySignatureAlgorithm(algorithm)
+	if err := checkDigest(alg, digest); err != nil {
+		return nil, err
+	}
+
+	result, err := k.client.Sign(ctx, ref.VaultURL, ref.Name, ref.Version, keyvault.KeySignParameters{
+		Algorithm: alg,
+		Value:     to.StringPtr(base64.RawURLEncoding.EncodeToString(digest)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newKeyOperationResult(result)
+}
+
+// SignData hashes the data with the algorithm's hash and signs the digest
+func (k *KeyVault) SignData(ctx context.Context, keyRef, algorithm string, data []byte) (*KeyOperationResult, error) {
+	digest, err := Digest(algorithm, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.Sign(ctx, keyRef, algorithm, digest)
+}
+
+// Verify checks a signature over a digest with the key
+func (k *KeyVault) Verify(ctx context.Context, keyRef, algorithm string, digest, signature []byte) (bool, error) {
+	ref, err := k.ParseKeyRef(keyRef)
+	if err != nil {
+		return false, err
+	}
+
+	alg := keyvault.JSONWebKeySignatureAlgorithm(algorithm)
+	if err := checkDigest(alg, digest); err != nil {
+		return false, err
+	}
+
+	result, err := k.client.Verify(ctx, ref.VaultURL, ref.Name, ref.Version, keyvault.KeyVerifyParameters{
+		Algorithm: alg,
+		Digest:    to.StringPtr(base64.RawURLEncoding.EncodeToString(digest)),
+		Signature: to.StringPtr(base64.RawURLEncoding.EncodeToString(signature)),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return to.Bool(result.Value), nil
+}
+
+// VerifyData hashes the data with the algorithm's hash and verifies the signature over the digest
+func (k *KeyVault) VerifyData(ctx context.Context, keyRef, algorithm string, data, signature []byte) (bool, error) {
+	digest, err := Digest(algorithm, data)
+	if err != nil {
+		return false, err
+	}
+
+	return k.Verify(ctx, keyRef, algorithm, digest, signature)
+}
+
+// Encrypt encrypts a small payload with the key, e.g. with RSA-OAEP
+func (k *KeyVault) Encrypt(ctx context.Context, keyRef, algorithm string, plaintext []byte) (*KeyOperationResult, error) {
+	return k.keyOperation(ctx, keyRef, algorithm, plaintext, k.client.Encrypt)
+}
+
+// Decrypt decrypts a payload that was encrypted with the key
+func (k *KeyVault) Decrypt(ctx context.Context, keyRef, algorithm string, ciphertext []byte) (*KeyOperationResult, error) {
+	return k.keyOperation(ctx, keyRef, algorithm, ciphertext, k.client.Decrypt)
+}
+
+// WrapKey wraps a symmetric key with the key
+func (k *KeyVault) WrapKey(ctx context.Context, keyRef, algorithm string, key []byte) (*KeyOperationResult, error) {
+	return k.keyOperation(ctx, keyRef, algorithm, key, k.client.WrapKey)
+}
+
+// UnwrapKey unwraps a symmetric key that was wrapped with the key
+func (k *KeyVault) UnwrapKey(ctx context.Context, keyRef, algorithm string, wrappedKey []byte) (*KeyOperationResult, error) {
+	return k.keyOperation(ctx, keyRef, algorithm, wrappedKey, k.client.UnwrapKey)
+}
+
+// Digest hashes the data with the hash used by the signature algorithm
+func Digest(algorithm string, data []byte) ([]byte, error) {
+	hash, ok := signatureHashes[keyvault.JSONWebKeySignatureAlgorithm(algorithm)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature algorithm '%s'", algorithm)
+	}
+
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+type keyOperationFunc func(ctx context.Context, vaultBaseURL, keyName, keyVersion string, parameters keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error)
+
+func (k *KeyVault) keyOperation(ctx context.Context, keyRef, algorithm string, value []byte, op keyOperationFunc) (*KeyOperationResult, error) {
+	ref, err := k.ParseKeyRef(keyRef)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := keyvault.JSONWebKeyEncryptionAlgorithm(algorithm)
+	if !validEncryptionAlgorithm(alg) {
+		return nil, fmt.Errorf("unsupported encryption algorithm '%s'", algorithm)
+	}
+
+	result, err := op(ctx, ref.VaultURL, ref.Name, ref.Version, keyvault.KeyOperationsParameters{
+		Algorithm: alg,
+		Value:     to.StringPtr(base64.RawURLEncoding.EncodeToString(value)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newKeyOperationResult(result)
+}
+
+func newKeyOperationResult(result keyvault.KeyOperationResult) (*KeyOperationResult, error) {
+	// Tolerate padded results, keyvault documents the value as unpadded base64url
+	value, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(to.String(result.Result), "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key operation result: %v", err)
+	}
+
+	return &KeyOperationResult{
+		KeyID:  to.String(result.Kid),
+		Result: value,
+	}, nil
+}
+
+func checkDigest(alg keyvault.JSONWebKeySignatureAlgorithm, digest []byte) error {
+	hash, ok := signatureHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported signature algorithm '%s'", alg)
+	}
+
+	if len(digest) != hash.Size() {
+		return fmt.Errorf("%s expects a %d byte digest, got %d bytes", alg, hash.Size(), len(digest))
+	}
+
+	return nil
+}
+
+func validEncryptionAlgorithm(alg keyvault.JSONWebKeyEncryptionAlgorithm) bool {
+	for _, valid := range keyvault.PossibleJSONWebKeyEncryptionAlgorithmValues() {
+		if alg == valid {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,190 @@
+package azkeyvault
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	secretsCollection = "secrets"
+	keysCollection    = "keys"
+)
+
+// SecretRef identifies a secret, and optionally a single version of it, in a vault
+type SecretRef struct {
+	VaultURL string
+	Name     string
+	Version  string
+}
+
+// String returns the secret identifier URL for the reference
+func (r SecretRef) String() string {
+	return objectID(r.VaultURL, secretsCollection, r.Name, r.Version)
+}
+
+// KeyRef identifies a key, and optionally a single version of it, in a vault
+type KeyRef struct {
+	VaultURL string
+	Name     string
+	Version  string
+}
+
+// String returns the key identifier URL for the reference
+func (r KeyRef) String() string {
+	return objectID(r.VaultURL, keysCollection, r.Name, r.Version)
+}
+
+// ParseSecretRef parses a bare secret name, a name@version pair or a full
+// secret identifier URL. Names and name@version pairs are resolved against
+// the client's vault, identifier URLs keep the vault they point to.
+func (k *KeyVault) ParseSecretRef(secretRef string) (SecretRef, error) {
+	vaultURL, name, version, err := k.parseRef(secretRef, secretsCollection)
+	if err != nil {
+		return SecretRef{}, err
+	}
+
+	return SecretRef{VaultURL: vaultURL, Name: name, Version: version}, nil
+}
+
+// ParseKeyRef parses a bare key name, a name@version pair or a full key
+// identifier URL, the same way as ParseSecretRef.
+func (k *KeyVault) ParseKeyRef(keyRef string) (KeyRef, error) {
+	vaultURL, name, version, err := k.parseRef(keyRef, keysCollection)
+	if err != nil {
+		return KeyRef{}, err
+	}
+
+	return KeyRef{VaultURL: vaultURL, Name: name, Version: version}, nil
+}
+
+func (k *KeyVault) parseRef(ref, collection string) (vaultURL, name, version string, err error) {
+	if strings.HasPrefix(ref, "https://") {
+		vaultURL, name, version, err = parseObjectID(ref, collection)
+		if err != nil {
+			return "", "", "", err
+		}
+
+		if err := k.checkVaultURL(vaultURL); err != nil {
+			return "", "", "", err
+		}
+
+		return vaultURL, name, version, nil
+	}
+
+	if k.vaultURL == "" {
+		return "", "", "", fmt.Errorf("'%s' must be a full identifier, no default vault is configured", ref)
+	}
+
+	name = ref
+	if idx := strings.Index(ref, "@"); idx >= 0 {
+		name, version = ref[:idx], ref[idx+1:]
+		if version == "" {
+			return "", "", "", fmt.Errorf("reference '%s' has an empty version", ref)
+		}
+	}
+
+	if err := validateName(name); err != nil {
+		return "", "", "", err
+	}
+
+	return k.vaultURL, name, version, nil
+}
+
+// parseSecretID parses a secret identifier of the form
+// https://<vault>.vault.azure.net/secrets/<name>[/<version>]
+func parseSecretID(id string) (SecretRef, error) {
+	vaultURL, name, version, err := parseObjectID(id, secretsCollection)
+	if err != nil {
+		return SecretRef{}, err
+	}
+
+	return SecretRef{VaultURL: vaultURL, Name: name, Version: version}, nil
+}
+
+// parseObjectID parses an identifier of the form
+// https://<vault>.vault.azure.net/<collection>/<name>[/<version>]
+func parseObjectID(id, collection string) (vaultURL, name, version string, err error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid identifier '%s': %v", id, err)
+	}
+
+	if u.Scheme != "https" || u.Host == "" {
+		return "", "", "", fmt.Errorf("invalid identifier '%s': must be an https URL", id)
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != collection {
+		return "", "", "", fmt.Errorf("invalid identifier '%s': expected /%s/<name>[/<version>]", id, collection)
+	}
+
+	name = parts[1]
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+
+	if err := validateName(name); err != nil {
+		return "", "", "", err
+	}
+
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host), name, version, nil
+}
+
+// checkVaultURL makes sure a vault URL taken from a reference points to a
+// vault in the client's cloud. The vault's bearer token is sent to it, so a
+// reference must not be able to name any other host.
+func (k *KeyVault) checkVaultURL(vaultURL string) error {
+	u, err := url.Parse(vaultURL)
+	if err != nil {
+		return fmt.Errorf("invalid vault URL '%s': %v", vaultURL, err)
+	}
+
+	suffix := "." + strings.ToLower(strings.Trim(k.dnsSuffix, "."))
+	host := strings.ToLower(u.Hostname())
+	if k.dnsSuffix == "" || u.Scheme != "https" || u.User != nil || (u.Port() != "" && u.Port() != "443") ||
+		!strings.HasSuffix(host, suffix) || !isVaultName(strings.TrimSuffix(host, suffix)) {
+		return fmt.Errorf("'%s' is not a vault in this cloud, vault hosts must end in %s", vaultURL, suffix)
+	}
+
+	return nil
+}
+
+// isVaultName checks the name against the characters keyvault allows in vault names
+func isVaultName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func objectID(vaultURL, collection, name, version string) string {
+	id := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(vaultURL, "/"), collection, name)
+	if version != "" {
+		id += "/" + version
+	}
+
+	return id
+}
+
+// validateName checks the name against the characters keyvault allows
+func validateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return fmt.Errorf("invalid name '%s': only alphanumerics and dashes are allowed", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package azkeyvault
+
+import "testing"
+
+func newTestKeyVault() *KeyVault {
+	return &KeyVault{
+		vaultURL:  "https://myvault.vault.azure.net",
+		dnsSuffix: "vault.azure.net",
+	}
+}
+
+func TestParseSecretRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    SecretRef
+		wantErr bool
+	}{
+		{ref: "db-password", want: SecretRef{VaultURL: "https://myvault.vault.azure.net", Name: "db-password"}},
+		{ref: "db-password@abc123", want: SecretRef{VaultURL: "https://myvault.vault.azure.net", Name: "db-password", Version: "abc123"}},
+		{ref: "https://other.vault.azure.net/secrets/tls-key", want: SecretRef{VaultURL: "https://other.vault.azure.net", Name: "tls-key"}},
+		{ref: "https://other.vault.azure.net/secrets/tls-key/abc123", want: SecretRef{VaultURL: "https://other.vault.azure.net", Name: "tls-key", Version: "abc123"}},
+		{ref: "https://OTHER.vault.azure.net:443/secrets/tls-key/", want: SecretRef{VaultURL: "https://OTHER.vault.azure.net:443", Name: "tls-key"}},
+		{ref: "", wantErr: true},
+		{ref: "db-password@", wantErr: true},
+		{ref: "db_password", wantErr: true},
+		{ref: "https://other.vault.azure.net/keys/tls-key", wantErr: true},
+		{ref: "https://other.vault.azure.net/secrets/tls-key/abc/extra", wantErr: true},
+		{ref: "https://other.vault.azure.net/secrets/tls_key", wantErr: true},
+		{ref: "https://evil.example.com/secrets/tls-key", wantErr: true},
+		{ref: "https://other.vault.azure.net.evil.example.com/secrets/tls-key", wantErr: true},
+		{ref: "https://other.vault.azure.net:8443/secrets/tls-key", wantErr: true},
+		{ref: "https://a.b.vault.azure.net/secrets/tls-key", wantErr: true},
+		{ref: "https://vault.azure.net/secrets/tls-key", wantErr: true},
+	}
+
+	k := newTestKeyVault()
+	for _, tt := range tests {
+		got, err := k.ParseSecretRef(tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSecretRef(%q) = %+v, want an error", tt.ref, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseSecretRef(%q) failed: %v", tt.ref, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseSecretRef(%q) = %+v, want %+v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestParseSecretRefWithoutVault(t *testing.T) {
+	k := &KeyVault{dnsSuffix: "vault.azure.net"}
+
+	if _, err := k.ParseSecretRef("db-password"); err == nil {
+		t.Error("a bare name without a default vault should fail")
+	}
+
+	ref, err := k.ParseSecretRef("https://myvault.vault.azure.net/secrets/db-password")
+	if err != nil {
+		t.Fatalf("ParseSecretRef failed: %v", err)
+	}
+
+	if ref.VaultURL != "https://myvault.vault.azure.net" {
+		t.Errorf("VaultURL = %q", ref.VaultURL)
+	}
+}
+
+func TestParseKeyRef(t *testing.T) {
+	k := newTestKeyVault()
+
+	ref, err := k.ParseKeyRef("https://myvault.vault.azure.net/keys/signing/v1")
+	if err != nil {
+		t.Fatalf("ParseKeyRef failed: %v", err)
+	}
+
+	want := KeyRef{VaultURL: "https://myvault.vault.azure.net", Name: "signing", Version: "v1"}
+	if ref != want {
+		t.Errorf("ParseKeyRef = %+v, want %+v", ref, want)
+	}
+
+	if _, err := k.ParseKeyRef("https://myvault.vault.azure.net/secrets/signing"); err == nil {
+		t.Error("a secret identifier should not parse as a key reference")
+	}
+}
+
+func TestRefString(t *testing.T) {
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{SecretRef{VaultURL: "https://myvault.vault.azure.net/", Name: "a"}.String(), "https://myvault.vault.azure.net/secrets/a"},
+		{SecretRef{VaultURL: "https://myvault.vault.azure.net", Name: "a", Version: "v1"}.String(), "https://myvault.vault.azure.net/secrets/a/v1"},
+		{KeyRef{VaultURL: "https://myvault.vault.azure.net", Name: "k"}.String(), "https://myvault.vault.azure.net/keys/k"},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("String() = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package azkeyvault
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	kvScheme         = "kv://"
+	appServicePrefix = "@Microsoft.KeyVault("
+)
+
+// IsReference reports whether the value is a keyvault reference of the form
+// kv://<vault>/<secret>[/<version>] or @Microsoft.KeyVault(...)
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, kvScheme) || strings.HasPrefix(value, appServicePrefix)
+}
+
+// ParseReference parses a kv:// or @Microsoft.KeyVault(...) reference into a
+// secret reference. The App Service syntax accepts either SecretUri=<id> or
+// VaultName=<vault>;SecretName=<name>[;SecretVersion=<version>].
+func (k *KeyVault) ParseReference(value string) (SecretRef, error) {
+	switch {
+	case strings.HasPrefix(value, kvScheme):
+		parts := strings.Split(strings.TrimPrefix(value, kvScheme), "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return SecretRef{}, fmt.Errorf("invalid reference '%s': expected kv://<vault>/<secret>[/<version>]", value)
+		}
+
+		ref := SecretRef{
+			VaultURL: k.vaultHostURL(parts[0]),
+			Name:     parts[1],
+		}
+		if len(parts) == 3 {
+			ref.Version = parts[2]
+		}
+
+		return k.checkSecretRef(ref)
+
+	case strings.HasPrefix(value, appServicePrefix) && strings.HasSuffix(value, ")"):
+		params := map[string]string{}
+		for _, param := range strings.Split(value[len(appServicePrefix):len(value)-1], ";") {
+			idx := strings.Index(param, "=")
+			if idx < 0 {
+				continue
+			}
+			params[strings.ToLower(strings.TrimSpace(param[:idx]))] = strings.TrimSpace(param[idx+1:])
+		}
+
+		if uri, ok := params["secreturi"]; ok {
+			ref, err := parseSecretID(uri)
+			if err != nil {
+				return SecretRef{}, err
+			}
+			return k.checkSecretRef(ref)
+		}
+
+		if params["vaultname"] == "" || params["secretname"] == "" {
+			return SecretRef{}, fmt.Errorf("invalid reference '%s': expected SecretUri or VaultName and SecretName", value)
+		}
+
+		ref := SecretRef{
+			VaultURL: k.vaultHostURL(params["vaultname"]),
+			Name:     params["secretname"],
+			Version:  params["secretversion"],
+		}
+
+		return k.checkSecretRef(ref)
+	}
+
+	return SecretRef{}, fmt.Errorf("'%s' is not a keyvault reference", value)
+}
+
+// ResolveValue returns the secret value for a keyvault reference, any other
+// value is returned unchanged.
+func (k *KeyVault) ResolveValue(ctx context.Context, value string) (string, error) {
+	if !IsReference(value) {
+		return value, nil
+	}
+
+	ref, err := k.ParseReference(value)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := k.GetSecret(ctx, ref.String())
+	if err != nil {
+		return "", err
+	}
+
+	return secret.Value, nil
+}
+
+// ResolveEnv resolves every keyvault reference in a list of KEY=VALUE pairs,
+// as returned by os.Environ, and returns the resolved list.
+func (k *KeyVault) ResolveEnv(ctx context.Context, environ []string) ([]string, error) {
+	resolved := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		idx := strings.Index(kv, "=")
+		if idx < 0 || !IsReference(kv[idx+1:]) {
+			resolved = append(resolved, kv)
+			continue
+		}
+
+		value, err := k.ResolveValue(ctx, kv[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %v", kv[:idx], err)
+		}
+
+		resolved = append(resolved, kv[:idx]+"="+value)
+	}
+
+	return resolved, nil
+}
+
+// checkSecretRef validates a parsed reference, the vault must be in the client's cloud
+func (k *KeyVault) checkSecretRef(ref SecretRef) (SecretRef, error) {
+	if err := validateName(ref.Name); err != nil {
+		return SecretRef{}, err
+	}
+
+	if err := k.checkVaultURL(ref.VaultURL); err != nil {
+		return SecretRef{}, err
+	}
+
+	return ref, nil
+}
+
+// vaultHostURL builds the vault URL from a vault name or a full vault host
+// name, checkVaultURL decides whether the host is acceptable
+func (k *KeyVault) vaultHostURL(vault string) string {
+	if strings.Contains(vault, ".") {
+		return "https://" + vault
+	}
+
+	return fmt.Sprintf("https://%s.%s", vault, k.dnsSuffix)
+}
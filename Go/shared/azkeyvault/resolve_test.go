@@ -0,0 +1,114 @@
+package azkeyvault
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestIsReference(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"kv://myvault/db-password", true},
+		{"@Microsoft.KeyVault(SecretUri=https://myvault.vault.azure.net/secrets/a)", true},
+		{"plain value", false},
+		{"", false},
+		{"https://myvault.vault.azure.net/secrets/a", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsReference(tt.value); got != tt.want {
+			t.Errorf("IsReference(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    SecretRef
+		wantErr bool
+	}{
+		{value: "kv://myvault/db-password", want: SecretRef{VaultURL: "https://myvault.vault.azure.net", Name: "db-password"}},
+		{value: "kv://myvault/db-password/v1", want: SecretRef{VaultURL: "https://myvault.vault.azure.net", Name: "db-password", Version: "v1"}},
+		{value: "kv://other.vault.azure.net/db-password", want: SecretRef{VaultURL: "https://other.vault.azure.net", Name: "db-password"}},
+		{value: "@Microsoft.KeyVault(SecretUri=https://other.vault.azure.net/secrets/tls-key/v2)", want: SecretRef{VaultURL: "https://other.vault.azure.net", Name: "tls-key", Version: "v2"}},
+		{value: "@Microsoft.KeyVault(VaultName=other; SecretName=tls-key; SecretVersion=v2)", want: SecretRef{VaultURL: "https://other.vault.azure.net", Name: "tls-key", Version: "v2"}},
+		{value: "@Microsoft.KeyVault(vaultname=other;secretname=tls-key)", want: SecretRef{VaultURL: "https://other.vault.azure.net", Name: "tls-key"}},
+		{value: "kv://myvault", wantErr: true},
+		{value: "kv:///db-password", wantErr: true},
+		{value: "kv://myvault/a/b/c", wantErr: true},
+		{value: "kv://myvault/bad_name", wantErr: true},
+		{value: "kv://evil.example.com/db-password", wantErr: true},
+		{value: "kv://evil.example.com@myvault.vault.azure.net/db-password", wantErr: true},
+		{value: "kv://myvault.vault.azure.net.evil.example.com/db-password", wantErr: true},
+		{value: "kv://my:vault/db-password", wantErr: true},
+		{value: "@Microsoft.KeyVault(SecretUri=https://evil.example.com/secrets/tls-key)", wantErr: true},
+		{value: "@Microsoft.KeyVault(VaultName=evil.example.com;SecretName=tls-key)", wantErr: true},
+		{value: "@Microsoft.KeyVault(VaultName=other)", wantErr: true},
+		{value: "@Microsoft.KeyVault(VaultName=other;SecretName=tls-key", wantErr: true},
+		{value: "plain value", wantErr: true},
+	}
+
+	k := newTestKeyVault()
+	for _, tt := range tests {
+		got, err := k.ParseReference(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseReference(%q) = %+v, want an error", tt.value, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseReference(%q) failed: %v", tt.value, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseReferenceOtherCloud(t *testing.T) {
+	k := &KeyVault{dnsSuffix: "vault.azure.cn"}
+
+	ref, err := k.ParseReference("kv://myvault/db-password")
+	if err != nil {
+		t.Fatalf("ParseReference failed: %v", err)
+	}
+
+	if ref.VaultURL != "https://myvault.vault.azure.cn" {
+		t.Errorf("VaultURL = %q", ref.VaultURL)
+	}
+
+	if _, err := k.ParseReference("kv://myvault.vault.azure.net/db-password"); err == nil {
+		t.Error("a vault in another cloud should be refused")
+	}
+}
+
+func TestResolveEnvLeavesPlainValues(t *testing.T) {
+	k := newTestKeyVault()
+	environ := []string{"PATH=/usr/bin", "EMPTY=", "NOEQUALS", "URL=https://example.com/?a=b"}
+
+	resolved, err := k.ResolveEnv(context.Background(), environ)
+	if err != nil {
+		t.Fatalf("ResolveEnv failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(resolved, environ) {
+		t.Errorf("ResolveEnv = %q, want %q", resolved, environ)
+	}
+}
+
+func TestResolveEnvInvalidReference(t *testing.T) {
+	k := newTestKeyVault()
+
+	_, err := k.ResolveEnv(context.Background(), []string{"TOKEN=kv://evil.example.com/token"})
+	if err == nil {
+		t.Fatal("ResolveEnv should refuse a reference to another host")
+	}
+}
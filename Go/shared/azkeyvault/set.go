@@ -0,0 +1,113 @@
+package azkeyvault
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/date"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+// SetSecretOptions holds the metadata stored with a new secret version
+type SetSecretOptions struct {
+	ContentType string
+	Tags        map[string]string
+	NotBefore   *time.Time
+	Expires     *time.Time
+
+	// Force writes a new version even if the secret already exists
+	Force bool
+}
+
+// SetSecret stores the value as a new version of the secret. Unless forced,
+// an existing secret is left alone and returned with created set to false,
+// so bootstrap jobs can run more than once without rotating credentials.
+func (k *KeyVault) SetSecret(ctx context.Context, secretName, value string, opts SetSecretOptions) (secret *Secret, created bool, err error) {
+	ref, err := k.ParseSecretRef(secretName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if ref.Version != "" {
+		return nil, false, fmt.Errorf("cannot set a pinned secret version '%s'", secretName)
+	}
+
+	if opts.NotBefore != nil && opts.Expires != nil && !opts.Expires.After(*opts.NotBefore) {
+		return nil, false, fmt.Errorf("secret '%s' would expire before it becomes active", ref.Name)
+	}
+
+	if !opts.Force {
+		existing, err := k.GetSecret(ctx, ref.String())
+		switch {
+		case err == nil:
+			return existing, false, nil
+		case isSecretDisabled(err):
+			// A disabled secret can't be read but still exists
+			disabled := &Secret{SecretProperties: SecretProperties{ID: ref.String(), Name: ref.Name}}
+			return disabled, false, nil
+		case !IsNotFound(err):
+			return nil, false, err
+		}
+	}
+
+	params := keyvault.SecretSetParameters{
+		Value:            to.StringPtr(value),
+		Tags:             *to.StringMapPtr(opts.Tags),
+		SecretAttributes: &keyvault.SecretAttributes{Enabled: to.BoolPtr(true)},
+	}
+
+	if opts.ContentType != "" {
+		params.ContentType = to.StringPtr(opts.ContentType)
+	}
+	if opts.NotBefore != nil {
+		nbf := date.UnixTime(*opts.NotBefore)
+		params.SecretAttributes.NotBefore = &nbf
+	}
+	if opts.Expires != nil {
+		exp := date.UnixTime(*opts.Expires)
+		params.SecretAttributes.Expires = &exp
+	}
+
+	bundle, err := k.client.SetSecret(ctx, ref.VaultURL, ref.Name, params)
+	if err != nil {
+		return nil, false, err
+	}
+
+	secret = &Secret{
+		SecretProperties: newSecretProperties(bundle.ID, bundle.ContentType, bundle.Tags, bundle.Attributes),
+		Value:            to.String(bundle.Value),
+	}
+
+	return secret, true, nil
+}
+
+// IsNotFound reports whether a keyvault request failed because the object doesn't exist
+func IsNotFound(err error) bool {
+	if de, ok := err.(autorest.DetailedError); ok {
+		return de.StatusCode == http.StatusNotFound
+	}
+
+	return false
+}
+
+// isSecretDisabled reports whether keyvault refused to return a secret
+// because it has been disabled, which answers 403 instead of the secret
+func isSecretDisabled(err error) bool {
+	de, ok := err.(autorest.DetailedError)
+	if !ok || de.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	re, ok := de.Original.(*azure.RequestError)
+	if !ok || re.ServiceError == nil {
+		return false
+	}
+
+	code, _ := re.ServiceError.InnerError["code"].(string)
+	return code == "SecretDisabled"
+}
@@ -0,0 +1,135 @@
+package azkeyvault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+)
+
+// setVault answers the existence check with a fixed status and records writes
+type setVault struct {
+	getStatus int
+	getBody   string
+	methods   []string
+	written   map[string]interface{}
+}
+
+func (v *setVault) Do(r *http.Request) (*http.Response, error) {
+	v.methods = append(v.methods, r.Method)
+
+	resp := &http.Response{Request: r, Header: http.Header{}, StatusCode: http.StatusOK}
+	switch r.Method {
+	case http.MethodGet:
+		resp.StatusCode = v.getStatus
+		resp.Body = ioutil.NopCloser(strings.NewReader(v.getBody))
+	case http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &v.written)
+		resp.Body = ioutil.NopCloser(strings.NewReader(`{"value":"new","id":"https://myvault.vault.azure.net/secrets/db-password/v2"}`))
+	}
+
+	return resp, nil
+}
+
+func newSetKeyVault(v *setVault) *KeyVault {
+	client := keyvault.New()
+	client.Sender = v
+	client.RetryAttempts = 0
+
+	k := newTestKeyVault()
+	k.client = &client
+	return k
+}
+
+func TestSetSecret(t *testing.T) {
+	existing := `{"value":"old","id":"https://myvault.vault.azure.net/secrets/db-password/v1"}`
+	disabled := `{"error":{"code":"Forbidden","message":"Operation get is not allowed on a disabled secret.","innererror":{"code":"SecretDisabled"}}}`
+	denied := `{"error":{"code":"Forbidden","message":"Access denied","innererror":{"code":"AccessDenied"}}}`
+	notFound := `{"error":{"code":"SecretNotFound","message":"Secret not found: db-password"}}`
+
+	tests := []struct {
+		name        string
+		vault       setVault
+		force       bool
+		wantCreated bool
+		wantVersion string
+		wantErr     bool
+		wantMethods string
+	}{
+		{name: "missing", vault: setVault{getStatus: 404, getBody: notFound}, wantCreated: true, wantVersion: "v2", wantMethods: "GET PUT"},
+		{name: "exists", vault: setVault{getStatus: 200, getBody: existing}, wantVersion: "v1", wantMethods: "GET"},
+		{name: "disabled", vault: setVault{getStatus: 403, getBody: disabled}, wantMethods: "GET"},
+		{name: "denied", vault: setVault{getStatus: 403, getBody: denied}, wantErr: true, wantMethods: "GET"},
+		{name: "forced", vault: setVault{getStatus: 200, getBody: existing}, force: true, wantCreated: true, wantVersion: "v2", wantMethods: "PUT"},
+	}
+
+	for _, tt := range tests {
+		k := newSetKeyVault(&tt.vault)
+
+		secret, created, err := k.SetSecret(context.Background(), "db-password", "new", SetSecretOptions{Force: tt.force})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: SetSecret should fail", tt.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: SetSecret failed: %v", tt.name, err)
+			continue
+		} else {
+			if created != tt.wantCreated {
+				t.Errorf("%s: created = %v, want %v", tt.name, created, tt.wantCreated)
+			}
+			if secret.Name != "db-password" || secret.Version != tt.wantVersion {
+				t.Errorf("%s: secret = %s@%s, want db-password@%s", tt.name, secret.Name, secret.Version, tt.wantVersion)
+			}
+		}
+
+		if got := strings.Join(tt.vault.methods, " "); got != tt.wantMethods {
+			t.Errorf("%s: requests = %s, want %s", tt.name, got, tt.wantMethods)
+		}
+	}
+}
+
+func TestSetSecretOptions(t *testing.T) {
+	v := &setVault{getStatus: 404, getBody: `{"error":{"code":"SecretNotFound"}}`}
+	k := newSetKeyVault(v)
+
+	nbf := time.Unix(1500000000, 0)
+	exp := nbf.Add(time.Hour)
+	opts := SetSecretOptions{
+		ContentType: PEMContentType,
+		Tags:        map[string]string{"app": "orders"},
+		NotBefore:   &nbf,
+		Expires:     &exp,
+	}
+
+	if _, _, err := k.SetSecret(context.Background(), "db-password", "new", opts); err != nil {
+		t.Fatalf("SetSecret failed: %v", err)
+	}
+
+	attributes, _ := v.written["attributes"].(map[string]interface{})
+	tags, _ := v.written["tags"].(map[string]interface{})
+	if v.written["value"] != "new" || v.written["contentType"] != PEMContentType || tags["app"] != "orders" ||
+		attributes["nbf"] != float64(nbf.Unix()) || attributes["exp"] != float64(exp.Unix()) || attributes["enabled"] != true {
+		t.Errorf("SetSecret wrote %v", v.written)
+	}
+}
+
+func TestSetSecretRejects(t *testing.T) {
+	k := newSetKeyVault(&setVault{})
+
+	nbf := time.Now()
+	exp := nbf.Add(-time.Hour)
+	if _, _, err := k.SetSecret(context.Background(), "db-password", "new", SetSecretOptions{NotBefore: &nbf, Expires: &exp}); err == nil {
+		t.Error("a secret that expires before it's active should be refused")
+	}
+
+	if _, _, err := k.SetSecret(context.Background(), "db-password@v1", "new", SetSecretOptions{}); err == nil {
+		t.Error("a pinned version can't be set")
+	}
+}
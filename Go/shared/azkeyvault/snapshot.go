@@ -0,0 +1,140 @@
+package azkeyvault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Snapshot persists last-known-good secrets to disk encrypted with AES-256-GCM,
+// so a restarted container can come up while keyvault is unreachable.
+type Snapshot struct {
+	path string
+	aead cipher.AEAD
+
+	// mu serializes saves, saved is the generation of the last one written
+	// so an older copy of the secrets can't replace a newer one
+	mu    sync.Mutex
+	saved uint64
+}
+
+// NewSnapshot creates a snapshot stored at path and encrypted with a 32 byte key
+func NewSnapshot(path string, key []byte) (*Snapshot, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("snapshot key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{
+		path: path,
+		aead: aead,
+	}, nil
+}
+
+// Load reads the secrets from the snapshot. A missing snapshot is not an error.
+func (s *Snapshot) Load() (map[string]*Secret, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return map[string]*Secret{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("snapshot '%s' is truncated", s.path)
+	}
+
+	plaintext, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot '%s': %v", s.path, err)
+	}
+
+	secrets := map[string]*Secret{}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot '%s': %v", s.path, err)
+	}
+
+	return secrets, nil
+}
+
+// Save encrypts the secrets and atomically replaces the snapshot
+func (s *Snapshot) Save(secrets map[string]*Secret) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(secrets)
+}
+
+// saveGeneration saves the secrets unless a newer generation has already
+// been written. Copies of the secrets are numbered in the order they are
+// taken, so saves racing each other can't leave an older copy on disk.
+func (s *Snapshot) saveGeneration(generation uint64, secrets map[string]*Secret) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if generation <= s.saved {
+		return nil
+	}
+
+	if err := s.write(secrets); err != nil {
+		return err
+	}
+
+	s.saved = generation
+	return nil
+}
+
+// write encrypts the secrets and replaces the snapshot, the caller must hold the lock
+func (s *Snapshot) write(secrets map[string]*Secret) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	data := s.aead.Seal(nonce, nonce, plaintext, nil)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,137 @@
+package azkeyvault
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSnapshot(t *testing.T, key []byte) (*Snapshot, func()) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := NewSnapshot(filepath.Join(dir, "secrets.snapshot"), key)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return snapshot, func() { os.RemoveAll(dir) }
+}
+
+func testSecret(name, version string) *Secret {
+	return &Secret{
+		SecretProperties: SecretProperties{Name: name, Version: version},
+		Value:            "value-" + version,
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	snapshot, cleanup := newTestSnapshot(t, key)
+	defer cleanup()
+
+	secrets, err := snapshot.Load()
+	if err != nil || len(secrets) != 0 {
+		t.Fatalf("Load of a missing snapshot = %v, %v", secrets, err)
+	}
+
+	if err := snapshot.Save(map[string]*Secret{"db-password": testSecret("db-password", "v1")}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(snapshot.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("value-v1")) {
+		t.Error("the snapshot holds the secret in plaintext")
+	}
+
+	secrets, err = snapshot.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if s := secrets["db-password"]; s == nil || s.Version != "v1" || s.Value != "value-v1" {
+		t.Errorf("Load = %+v", secrets)
+	}
+
+	other, err := NewSnapshot(snapshot.path, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Load(); err == nil {
+		t.Error("a snapshot should not load with the wrong key")
+	}
+
+	if _, err := NewSnapshot(snapshot.path, key[:16]); err == nil {
+		t.Error("a short key should be refused")
+	}
+}
+
+func TestSnapshotDropsStaleGeneration(t *testing.T) {
+	snapshot, cleanup := newTestSnapshot(t, bytes.Repeat([]byte{1}, 32))
+	defer cleanup()
+
+	if err := snapshot.saveGeneration(2, map[string]*Secret{"a": testSecret("a", "v2")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The older copy loses the race to disk and must not replace the newer one
+	if err := snapshot.saveGeneration(1, map[string]*Secret{"a": testSecret("a", "v1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := snapshot.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["a"].Version != "v2" {
+		t.Errorf("snapshot holds version %s, want v2", secrets["a"].Version)
+	}
+}
+
+func TestSecretCacheConcurrentStores(t *testing.T) {
+	snapshot, cleanup := newTestSnapshot(t, bytes.Repeat([]byte{1}, 32))
+	defer cleanup()
+
+	cache := newTestKeyVault().NewSecretCache(time.Hour)
+	if err := cache.UseSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("secret-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := 0; v < 5; v++ {
+				cache.store(name, testSecret(name, fmt.Sprintf("v%d", v)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	secrets, err := snapshot.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != 8 {
+		t.Fatalf("snapshot holds %d secrets, want 8", len(secrets))
+	}
+
+	for ref, secret := range secrets {
+		if secret.Version != "v4" {
+			t.Errorf("snapshot holds %s version %s, want the last stored version v4", ref, secret.Version)
+		}
+	}
+}
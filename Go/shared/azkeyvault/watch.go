@@ -0,0 +1,99 @@
+package azkeyvault
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// SecretWatcher polls a secret and notifies subscribers when its version changes
+type SecretWatcher struct {
+	source    SecretGetter
+	secretRef string
+	interval  time.Duration
+
+	mu        sync.RWMutex
+	latest    *Secret
+	callbacks []func(*Secret)
+}
+
+// NewSecretWatcher creates a watcher for the secret. A reference pinned to a
+// version never changes, so only unpinned references are worth watching.
+func (k *KeyVault) NewSecretWatcher(secretRef string, interval time.Duration) *SecretWatcher {
+	return newSecretWatcher(k, secretRef, interval)
+}
+
+func newSecretWatcher(source SecretGetter, secretRef string, interval time.Duration) *SecretWatcher {
+	return &SecretWatcher{
+		source:    source,
+		secretRef: secretRef,
+		interval:  interval,
+	}
+}
+
+// OnChange registers a callback invoked with the new secret whenever the version changes
+func (w *SecretWatcher) OnChange(callback func(*Secret)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.callbacks = append(w.callbacks, callback)
+}
+
+// Latest returns the most recently fetched secret, or nil before the first poll
+func (w *SecretWatcher) Latest() *Secret {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.latest
+}
+
+// Poll fetches the secret once and invokes the callbacks if the version changed
+func (w *SecretWatcher) Poll(ctx context.Context) (bool, error) {
+	secret, err := w.source.GetSecret(ctx, w.secretRef)
+	if err != nil {
+		return false, err
+	}
+
+	w.mu.Lock()
+	if w.latest != nil && w.latest.Version == secret.Version {
+		w.mu.Unlock()
+		return false, nil
+	}
+
+	w.latest = secret
+	callbacks := make([]func(*Secret), len(w.callbacks))
+	copy(callbacks, w.callbacks)
+	w.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(secret)
+	}
+
+	return true, nil
+}
+
+// Run polls the secret on the watcher's interval until the context is done.
+// Failed polls are logged and retried on the next tick, keeping the last
+// known version in place.
+func (w *SecretWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := w.Poll(ctx)
+			if err != nil {
+				log.Printf("Failed to poll secret '%s': %v", w.secretRef, err)
+				continue
+			}
+
+			if changed {
+				log.Printf("Secret '%s' changed to version '%s'", w.secretRef, w.Latest().Version)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package azkeyvault
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// newServerKeyVault returns a client whose default vault is the test server
+func newServerKeyVault(srv *httptest.Server) *KeyVault {
+	client := keyvault.New()
+	client.Authorizer = autorest.NullAuthorizer{}
+
+	return &KeyVault{
+		client:    &client,
+		vaultURL:  srv.URL,
+		dnsSuffix: "vault.azure.net",
+	}
+}
+
+// fakeSecret serves a single secret whose version and value can be changed
+type fakeSecret struct {
+	mu      sync.Mutex
+	version string
+	value   string
+	status  int
+}
+
+func (f *fakeSecret) set(version, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.version, f.value, f.status = version, value, 0
+}
+
+func (f *fakeSecret) fail(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.status = status
+}
+
+// ServeHTTP answers GetSecret, ids always name the vault's https URL since
+// that is what keyvault returns regardless of how it was reached
+func (f *fakeSecret) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, `{"error":{"code":"SecretNotFound","message":"not found"}}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"https://myvault.vault.azure.net/secrets/db/%s","value":"%s"}`, f.version, f.value)
+}
+
+func TestSecretWatcherPoll(t *testing.T) {
+	secret := &fakeSecret{}
+	secret.set("v1", "first")
+
+	srv := httptest.NewServer(secret)
+	defer srv.Close()
+
+	w := newServerKeyVault(srv).NewSecretWatcher("db", 0)
+
+	var seen []string
+	w.OnChange(func(s *Secret) {
+		seen = append(seen, s.Version+"="+s.Value)
+	})
+
+	if w.Latest() != nil {
+		t.Fatal("Latest should be nil before the first poll")
+	}
+
+	steps := []struct {
+		version string
+		value   string
+		changed bool
+	}{
+		{"v1", "first", true},
+		{"v1", "first", false},
+		{"v2", "second", true},
+		{"v2", "second", false},
+	}
+
+	for _, step := range steps {
+		secret.set(step.version, step.value)
+
+		changed, err := w.Poll(context.Background())
+		if err != nil {
+			t.Fatalf("Poll failed: %v", err)
+		}
+
+		if changed != step.changed {
+			t.Errorf("Poll at %s changed = %v, want %v", step.version, changed, step.changed)
+		}
+
+		if latest := w.Latest(); latest == nil || latest.Version != step.version || latest.Value != step.value {
+			t.Errorf("Latest = %+v, want version %s", latest, step.version)
+		}
+	}
+
+	want := []string{"v1=first", "v2=second"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("callbacks saw %v, want %v", seen, want)
+	}
+}
+
+func TestSecretWatcherPollErrorKeepsLatest(t *testing.T) {
+	secret := &fakeSecret{}
+	secret.set("v1", "first")
+
+	srv := httptest.NewServer(secret)
+	defer srv.Close()
+
+	w := newServerKeyVault(srv).NewSecretWatcher("db", 0)
+	if _, err := w.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed: %v", err)
+	}
+
+	called := false
+	w.OnChange(func(*Secret) { called = true })
+
+	secret.fail(http.StatusNotFound)
+	if _, err := w.Poll(context.Background()); err == nil {
+		t.Fatal("Poll should fail when the secret can't be read")
+	}
+
+	if called {
+		t.Error("callbacks should not run on a failed poll")
+	}
+
+	if latest := w.Latest(); latest == nil || latest.Version != "v1" {
+		t.Errorf("Latest = %+v, want the last good version", latest)
+	}
+}
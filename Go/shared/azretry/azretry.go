@@ -0,0 +1,422 @@
+package azretry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Class groups failures by how they should be retried
+type Class int
+
+const (
+	// Permanent failures are returned straight away
+	Permanent Class = iota
+	// IMDSNotReady means the managed identity endpoint isn't serving tokens yet, common while a container starts
+	IMDSNotReady
+	// IdentityNotPropagated is a 403 that goes away once a new role assignment reaches the service
+	IdentityNotPropagated
+	// NotFound is returned straight away, retrying won't make the resource appear
+	NotFound
+	// Throttled requests are retried after the Retry-After the service asked for
+	Throttled
+	// Transient covers 5xx responses, timeouts and dropped connections
+	Transient
+)
+
+func (c Class) String() string {
+	switch c {
+	case IMDSNotReady:
+		return "IMDS not ready"
+	case IdentityNotPropagated:
+		return "identity not yet propagated"
+	case NotFound:
+		return "not found"
+	case Throttled:
+		return "throttled"
+	case Transient:
+		return "transient failure"
+	}
+
+	return "permanent failure"
+}
+
+// Retryable reports whether failures of the class are worth retrying
+func (c Class) Retryable() bool {
+	return c == IMDSNotReady || c == IdentityNotPropagated || c == Throttled || c == Transient
+}
+
+const (
+	// TimeoutVar overrides the overall deadline of the default policy, e.g. 5m
+	TimeoutVar = "AZURE_RETRY_TIMEOUT"
+
+	imdsHost = "169.254.169.254"
+)
+
+// Policy retries with exponential backoff and jitter until an overall deadline
+type Policy struct {
+	// InitialDelay is the delay before the first retry, it doubles on every retry
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between retries
+	MaxDelay time.Duration
+	// Timeout is the overall deadline across every attempt
+	Timeout time.Duration
+	// ForbiddenTimeout bounds how long a 403 is treated as a role assignment
+	// still propagating before it's returned as a real permission problem
+	ForbiddenTimeout time.Duration
+}
+
+// DefaultPolicy rides out a container start and a fresh role assignment
+var DefaultPolicy = Policy{
+	InitialDelay:     time.Second,
+	MaxDelay:         time.Second * 30,
+	Timeout:          time.Minute * 2,
+	ForbiddenTimeout: time.Minute,
+}
+
+// NoRetries makes a single attempt, e.g. for diagnostics that report failures as they happen
+var NoRetries = Policy{
+	InitialDelay: time.Second,
+	MaxDelay:     time.Second,
+}
+
+// FromEnvironment returns the default policy with the deadline from AZURE_RETRY_TIMEOUT
+func FromEnvironment() (Policy, error) {
+	p := DefaultPolicy
+
+	if val, ok := os.LookupEnv(TimeoutVar); ok {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return p, fmt.Errorf("invalid %s: %v", TimeoutVar, err)
+		}
+		p.Timeout = d
+	}
+
+	return p, nil
+}
+
+// Do calls fn until it succeeds, fails in a way that isn't worth retrying or
+// the policy's deadline passes. The name describes the operation in logs. A
+// zero timeout calls fn once.
+func (p Policy) Do(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		class := Classify(nil, err)
+		delay, ok := p.next(start, attempt, class, RetryAfter(responseOf(err)))
+		if !ok {
+			return err
+		}
+
+		log.Printf("Retrying %s in %s, %s: %v", name, delay, class, err)
+		if !Sleep(ctx, delay) {
+			return err
+		}
+	}
+}
+
+// SendDecorator retries requests sent by an autorest client, e.g.
+// client.Sender = autorest.DecorateSender(client.Sender, policy.SendDecorator())
+func (p Policy) SendDecorator() autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			start := time.Now()
+			rr := autorest.NewRetriableRequest(r)
+
+			for attempt := 0; ; attempt++ {
+				if err := rr.Prepare(); err != nil {
+					return nil, err
+				}
+
+				resp, err := s.Do(rr.Request())
+				if err == nil && resp.StatusCode < http.StatusBadRequest {
+					return resp, nil
+				}
+
+				class := Classify(resp, err)
+				delay, ok := p.next(start, attempt, class, RetryAfter(resp))
+				if !ok {
+					return resp, err
+				}
+
+				log.Printf("Retrying %s in %s, %s", Describe(r), delay, class)
+				Drain(resp)
+				if !Sleep(r.Context(), delay) {
+					// The response's body was drained and closed, don't hand it back
+					return nil, r.Context().Err()
+				}
+			}
+		})
+	}
+}
+
+// AuthorizeSender authorizes every try of a request inside the retries, so a
+// token that can't be acquired yet, e.g. while IMDS starts, is retried like
+// a failed request. It takes the place of the client's Authorizer, e.g.
+// client.Sender = autorest.DecorateSender(client.Sender, azretry.AuthorizeSender(authorizer), policy.SendDecorator())
+func AuthorizeSender(authorizer autorest.Authorizer) autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			r, err := autorest.Prepare(r, authorizer.WithAuthorization())
+			if err != nil {
+				return nil, err
+			}
+
+			return s.Do(r)
+		})
+	}
+}
+
+// Next returns how long to wait before the next attempt, and false if the
+// failure shouldn't be retried. Attempts are counted from zero.
+func (p Policy) Next(start time.Time, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	return p.next(start, attempt, Classify(resp, err), RetryAfter(resp))
+}
+
+func (p Policy) next(start time.Time, attempt int, class Class, retryAfter time.Duration) (time.Duration, bool) {
+	if !class.Retryable() {
+		return 0, false
+	}
+
+	elapsed := time.Since(start)
+	if class == IdentityNotPropagated && elapsed >= p.ForbiddenTimeout {
+		return 0, false
+	}
+
+	delay := p.backoff(attempt)
+	if class == Throttled && retryAfter > 0 {
+		delay = retryAfter
+	}
+
+	if elapsed+delay >= p.Timeout {
+		return 0, false
+	}
+
+	return delay, true
+}
+
+// backoff returns the exponential delay for the attempt with jitter between half and the full delay
+func (p Policy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 30 {
+		if d := p.InitialDelay << uint(attempt); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// MarkTransient marks an error the classifier can't recognise as transient,
+// e.g. a response body that stopped halfway
+func MarkTransient(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return transientError{err}
+}
+
+// MarkPermanent marks an error as not worth retrying, e.g. one that another
+// layer has retried already, so the retries don't multiply
+func MarkPermanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return permanentError{err}
+}
+
+type transientError struct {
+	error
+}
+
+type permanentError struct {
+	error
+}
+
+// Classify sorts a failed request into a retry class from its response or error
+func Classify(resp *http.Response, err error) Class {
+	switch err.(type) {
+	case transientError:
+		return Transient
+	case permanentError:
+		return Permanent
+	}
+
+	if resp == nil {
+		resp = responseOf(err)
+	}
+
+	if resp == nil && err != nil && strings.Contains(err.Error(), imdsHost) {
+		// Token requests that never got a response only show up in the message
+		return IMDSNotReady
+	}
+
+	if resp != nil {
+		if isIMDSResponse(resp) {
+			// IMDS answers 404 and 410 while the identity is being set up, and
+			// anything else, e.g. a 400 for an unknown identity, won't change
+			switch code := resp.StatusCode; {
+			case code == http.StatusNotFound, code == http.StatusGone, code >= http.StatusInternalServerError:
+				return IMDSNotReady
+			}
+		}
+
+		switch code := resp.StatusCode; {
+		case code == http.StatusForbidden:
+			return IdentityNotPropagated
+		case code == http.StatusNotFound:
+			return NotFound
+		case code == http.StatusTooManyRequests:
+			return Throttled
+		case code == http.StatusServiceUnavailable && RetryAfter(resp) > 0:
+			return Throttled
+		case code == http.StatusRequestTimeout, code >= http.StatusInternalServerError && code != http.StatusNotImplemented && code != http.StatusHTTPVersionNotSupported:
+			return Transient
+		case code >= http.StatusBadRequest:
+			return Permanent
+		case code >= http.StatusMultipleChoices:
+			// A condition that held, e.g. 304 Not Modified, gets the same answer every time.
+			// azblob's errors pass for network errors below, so this has to come first.
+			return Permanent
+		}
+	}
+
+	if err != nil && isNetworkError(err) {
+		return Transient
+	}
+
+	return Permanent
+}
+
+// RetryAfter returns the delay the service asked for in Retry-After or
+// x-ms-retry-after-ms, or zero if it didn't ask for one
+func RetryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+
+	if ms, err := strconv.Atoi(resp.Header.Get("x-ms-retry-after-ms")); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+
+	val := resp.Header.Get("Retry-After")
+	if val == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(val); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(val); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// Describe names a request for logs without its query string, which may carry a SAS token
+func Describe(r *http.Request) string {
+	u := *r.URL
+	u.RawQuery = ""
+	u.User = nil
+	return r.Method + " " + u.String()
+}
+
+// StatusCode returns the HTTP status a request failed with, zero if it never got a response
+func StatusCode(err error) int {
+	if resp := responseOf(err); resp != nil {
+		return resp.StatusCode
+	}
+
+	return 0
+}
+
+// responseOf digs the HTTP response out of the error types used by autorest, adal and azblob
+func responseOf(err error) *http.Response {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case transientError:
+		return responseOf(e.error)
+	case permanentError:
+		return responseOf(e.error)
+	case autorest.DetailedError:
+		if e.Response != nil {
+			return e.Response
+		}
+		return responseOf(e.Original)
+	case interface {
+		Response() *http.Response
+	}:
+		return e.Response()
+	}
+
+	return nil
+}
+
+func isIMDSResponse(resp *http.Response) bool {
+	return resp.Request != nil && resp.Request.URL != nil && resp.Request.URL.Hostname() == imdsHost
+}
+
+func isNetworkError(err error) bool {
+	switch e := err.(type) {
+	case autorest.DetailedError:
+		return isNetworkError(e.Original)
+	case *url.Error:
+		return true
+	case net.Error:
+		return true
+	}
+
+	return false
+}
+
+// Drain closes the body of a response that is about to be retried
+func Drain(resp *http.Response) {
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+}
+
+// Sleep waits for the delay and reports false if the context is done first
+func Sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}